@@ -0,0 +1,41 @@
+package gokad
+
+import (
+	"context"
+	"errors"
+)
+
+// Errors
+var ErrBootstrapFailed = errors.New("Bootstrap Failed")
+
+// Bootstrap joins the network the seeds belong to. The seeds are inserted into the
+// routing table and a lookup for our own id is performed, which populates the buckets
+// closest to us and announces us to our neighbours. Then every bucket further away than
+// the closest neighbour is refreshed.
+// Seeds without an ID are pinged first to learn it.
+// ErrBootstrapFailed is returned if none of the seeds could be reached
+// @Source: Kademlia: A Peer-to-peer Information System Based on the XOR Metric
+// https://pdos.csail.mit.edu/~petar/papers/maymounkov-kademlia-lncs.pdf
+func (dht *DHT) Bootstrap(ctx context.Context, seeds []Contact) error {
+	for _, c := range seeds {
+		if c.ID == nil {
+			dht.SendPing(ctx, c)
+			continue
+		}
+
+		if !c.ID.Equal(dht.ID) {
+			dht.routingTable.Add(c)
+		}
+	}
+
+	neighbours, err := dht.Lookup(ctx, dht.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(neighbours) == 0 {
+		return ErrBootstrapFailed
+	}
+
+	return dht.refreshBuckets(ctx, dht.routingTable.bucketsFartherThan(neighbours[0].ID))
+}
@@ -0,0 +1,58 @@
+package gokad
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBootstrapJoinsNetwork(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, contacts := newMemoryCluster(network, 30, 7)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	joining, self := newMemoryNode(network)
+
+	// the seed's id is learned by pinging it
+	seed := Contact{IP: contacts[0].IP, Port: contacts[0].Port}
+	if err := joining.Bootstrap(ctx, []Contact{seed}); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	expected := bruteForceClosest(contacts, nil, joining.ID, K)
+	known := joining.FindNode(joining.ID)
+	if len(known) != len(expected) {
+		t.Fatalf("Expected %d contacts to be known, but got %d\n", len(expected), len(known))
+	}
+
+	for i, c := range expected {
+		if !known[i].ID.Equal(c.ID) {
+			t.Errorf("Expected at index (%d) %s, but got %s\n", i, c.ID, known[i].ID)
+		}
+	}
+
+	// the closest neighbour learned about the joining node
+	for _, n := range nodes {
+		if !n.ID.Equal(expected[0].ID) {
+			continue
+		}
+
+		if got := n.FindNode(self.ID); len(got) == 0 || !got[0].ID.Equal(self.ID) {
+			t.Errorf("Expected %s to know about %s\n", n.ID, self.ID)
+		}
+	}
+}
+
+func TestBootstrapWithUnreachableSeeds(t *testing.T) {
+	network := NewMemoryNetwork()
+	joining, _ := newMemoryNode(network)
+
+	seeds := []Contact{generateRandomContact(), {IP: generateRandomContact().IP, Port: 1234}}
+	err := joining.Bootstrap(context.Background(), seeds)
+	if !errors.Is(err, ErrBootstrapFailed) {
+		t.Errorf("Expected error %s, but got %v\n", ErrBootstrapFailed, err)
+	}
+}
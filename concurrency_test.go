@@ -0,0 +1,152 @@
+package gokad
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The tests in this file hammer the exported api from many goroutines.
+// They are meant to be run with the race detector: go test -race
+
+const stressGoroutines = 16
+const stressIterations = 200
+
+func TestKBucketConcurrentAccess(t *testing.T) {
+	bucket := NewKBucket(0)
+	contacts := make([]Contact, 2*MaxCapacity)
+	for i := range contacts {
+		contacts[i] = generateRandomContact()
+	}
+
+	stress(func(g, i int) {
+		c := contacts[(g+i)%len(contacts)]
+		switch i % 6 {
+		case 0, 1:
+			bucket.Insert(c)
+		case 2:
+			bucket.Remove(c.ID)
+		case 3:
+			bucket.Fail(c.ID)
+		case 4:
+			bucket.Walk(func(c Contact) bool { return false })
+			bucket.Size()
+			bucket.IsEmpty()
+		case 5:
+			bucket.Replacements()
+			bucket.getXClosestContacts(3, c.ID)
+			bucket.LastLookup()
+			_ = bucket.String()
+		}
+	})
+
+	walked := 0
+	bucket.Walk(func(c Contact) bool {
+		walked++
+		return false
+	})
+
+	if walked != bucket.Size() || walked > MaxCapacity {
+		t.Errorf("Expected to walk %d contacts, but walked %d\n", bucket.Size(), walked)
+	}
+}
+
+func TestRoutingTableConcurrentAccess(t *testing.T) {
+	routing := NewRoutingTable(GenerateRandomID())
+	contacts := make([]Contact, 100)
+	for i := range contacts {
+		contacts[i] = generateRandomContact()
+	}
+
+	stress(func(g, i int) {
+		c := contacts[(g*7+i)%len(contacts)]
+		switch i % 5 {
+		case 0, 1:
+			routing.Add(c)
+		case 2:
+			routing.Remove(c.ID)
+		case 3:
+			routing.Fail(c.ID)
+			routing.contacted(c.ID)
+		case 4:
+			routing.GetAlphaNodes(ALPHA, c.ID)
+			routing.touch(c.ID)
+			routing.staleBuckets(time.Now())
+			buckets := routing.Buckets()
+			for _, b := range buckets {
+				b.Size()
+			}
+			if b, ok := routing.Bucket(len(buckets) - 1); ok {
+				b.Index()
+			}
+		}
+	})
+}
+
+func TestDHTConcurrentAccess(t *testing.T) {
+	dht := NewDHT()
+	keys := make([]ID, 10)
+	for i := range keys {
+		keys[i] = GenerateRandomID()
+	}
+
+	stress(func(g, i int) {
+		key := keys[(g+i)%len(keys)]
+		switch i % 4 {
+		case 0:
+			dht.Store(key, net.IPv4(10, 0, 0, byte(g)), i)
+		case 1:
+			dht.FindValue(key)
+		case 2:
+			dht.seen(generateRandomContact())
+		case 3:
+			dht.FindNode(key)
+		}
+	})
+}
+
+func TestConcurrentNetworkOperations(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, _ := newMemoryCluster(network, 20, 6)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *DHT) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				key := GenerateRandomID()
+				n.Put(ctx, key, HostPort{Host: net.IPv4(10, 0, 0, 1), Port: i}.Value())
+				n.Get(ctx, key)
+				n.Lookup(ctx, GenerateRandomID())
+			}
+		}(n)
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		t.Errorf("Expected operations to finish in time, but got %s\n", ctx.Err())
+	}
+}
+
+// stress runs fn from stressGoroutines goroutines stressIterations times each
+func stress(fn func(g, i int)) {
+	var wg sync.WaitGroup
+	for g := 0; g < stressGoroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < stressIterations; i++ {
+				fn(g, i)
+			}
+		}(g)
+	}
+
+	wg.Wait()
+}
@@ -1,7 +1,6 @@
 package gokad
 
 const (
-	ALPHA               = 3
-	K                   = 20
-	MaxRoutingTableSize = 160
+	ALPHA = 3
+	K     = 20
 )
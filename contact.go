@@ -2,27 +2,55 @@ package gokad
 
 import (
 	"encoding/binary"
+	"errors"
 	"net"
+	"time"
 )
 
+// Errors
+var ErrMalformedContact = errors.New("Malformed Contact")
+
 type Contact struct {
 	ID   ID
 	IP   net.IP
 	Port int
-	next *Contact
+	// LastSeen is the last time a message was received from the contact
+	LastSeen time.Time
+	// LastContacted is the last time an RPC was sent to the contact
+	LastContacted time.Time
+	next          *Contact
+	// failures counts the consecutive RPCs to the contact that failed
+	failures int
 }
 
-// 20 bytes id <- 2 bytes port <- 16 bytes ip
+// Compact contact sizes for ids of SIZE bytes
+const (
+	// ContactSizeIPv4 is the size of a serialized contact with an IPv4 address
+	ContactSizeIPv4 = SIZE + 2 + net.IPv4len
+	// ContactSizeIPv6 is the size of a serialized contact with an IPv6 address
+	ContactSizeIPv6 = SIZE + 2 + net.IPv6len
+)
+
+// Serialize writes the contact in its compact format:
+// n bytes id <- 2 bytes port <- 4 bytes ip (IPv4, 26 bytes in total for 20 byte ids)
+// or 16 bytes ip (IPv6, 38 bytes in total for 20 byte ids).
+// IPv4 addresses are always written as 4 bytes, no matter how the net.IP was constructed.
+// A contact without a valid ip is written with the unspecified IPv6 address
 func (c *Contact) Serialize() []byte {
 	id := c.ID
 
-	ip := []byte(c.IP)
+	ip := []byte(c.IP.To4())
+	if ip == nil {
+		ip = []byte(c.IP.To16())
+	}
+	if ip == nil {
+		ip = []byte(net.IPv6unspecified)
+	}
 
 	port := make([]byte, 2)
 	binary.BigEndian.PutUint16(port, uint16(c.Port))
 
-
-	concat := make([]byte, 0)
+	concat := make([]byte, 0, len(id)+2+len(ip))
 	concat = append(concat, id...)
 	concat = append(concat, port...)
 	concat = append(concat, ip...)
@@ -30,3 +58,71 @@ func (c *Contact) Serialize() []byte {
 	return concat
 
 }
+
+// DeserializeContact is the inverse of Contact.Serialize for contacts with ids of SIZE bytes.
+// b must be exactly ContactSizeIPv4 or ContactSizeIPv6 bytes long
+func DeserializeContact(b []byte) (Contact, error) {
+	return deserializeContact(b, SIZE)
+}
+
+// deserializeContact is the inverse of Contact.Serialize for contacts with ids of n bytes
+func deserializeContact(b []byte, n int) (Contact, error) {
+	if len(b) != n+2+net.IPv4len && len(b) != n+2+net.IPv6len {
+		return Contact{}, ErrMalformedContact
+	}
+
+	id := make(ID, n)
+	copy(id, b[:n])
+	ip := make(net.IP, len(b)-n-2)
+	copy(ip, b[n+2:])
+
+	return Contact{
+		ID:   id,
+		IP:   ip,
+		Port: int(binary.BigEndian.Uint16(b[n : n+2])),
+	}, nil
+}
+
+// SerializeContacts writes a list of contacts as used in FIND_NODE replies.
+// Every contact is prefixed with 1 byte holding its size (ContactSizeIPv4 or ContactSizeIPv6)
+func SerializeContacts(contacts []Contact) []byte {
+	out := make([]byte, 0, len(contacts)*(1+ContactSizeIPv4))
+	for _, c := range contacts {
+		out = appendSerializedContact(out, c)
+	}
+
+	return out
+}
+
+// DeserializeContacts is the inverse of SerializeContacts for contacts with ids of SIZE bytes
+func DeserializeContacts(b []byte) ([]Contact, error) {
+	return deserializeContacts(b, SIZE)
+}
+
+// deserializeContacts is the inverse of SerializeContacts for contacts with ids of n bytes
+func deserializeContacts(b []byte, n int) ([]Contact, error) {
+	out := make([]Contact, 0, len(b)/(1+n+2+net.IPv4len))
+	for len(b) > 0 {
+		l := int(b[0])
+		if len(b) < 1+l {
+			return nil, ErrMalformedContact
+		}
+
+		c, err := deserializeContact(b[1:1+l], n)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, c)
+		b = b[1+l:]
+	}
+
+	return out, nil
+}
+
+func appendSerializedContact(out []byte, c Contact) []byte {
+	s := c.Serialize()
+	out = append(out, byte(len(s)))
+
+	return append(out, s...)
+}
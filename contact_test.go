@@ -0,0 +1,89 @@
+package gokad
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestSerializeDeserializeContact(t *testing.T) {
+	cases := []struct {
+		contact Contact
+		size    int
+	}{
+		{Contact{ID: GenerateRandomID(), IP: net.IPv4(127, 0, 0, 1), Port: 3000}, ContactSizeIPv4},
+		{Contact{ID: GenerateRandomID(), IP: net.IPv4(127, 0, 0, 1).To4(), Port: 65535}, ContactSizeIPv4},
+		{Contact{ID: GenerateRandomID(), IP: net.ParseIP("2001:db8::1"), Port: 1}, ContactSizeIPv6},
+	}
+
+	for _, test := range cases {
+		c := test.contact
+		b := c.Serialize()
+		if len(b) != test.size {
+			t.Errorf("Expected %s to serialize to %d bytes, but got %d\n", c.IP, test.size, len(b))
+		}
+
+		out, err := DeserializeContact(b)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, but got %s\n", err)
+		}
+
+		if !out.ID.Equal(c.ID) || !out.IP.Equal(c.IP) || out.Port != c.Port {
+			t.Errorf("Expected %s %s:%d, but got %s %s:%d\n", c.ID, c.IP, c.Port, out.ID, out.IP, out.Port)
+		}
+	}
+}
+
+func TestDeserializeContactRejectsInvalidSizes(t *testing.T) {
+	sizes := []int{0, SIZE, ContactSizeIPv4 - 1, ContactSizeIPv4 + 1, ContactSizeIPv6 + 1}
+
+	for _, size := range sizes {
+		if _, err := DeserializeContact(make([]byte, size)); !errors.Is(err, ErrMalformedContact) {
+			t.Errorf("Expected error %s for %d bytes, but got %v\n", ErrMalformedContact, size, err)
+		}
+	}
+}
+
+func TestSerializeDeserializeContacts(t *testing.T) {
+	contacts := []Contact{
+		{ID: GenerateRandomID(), IP: net.IPv4(10, 0, 0, 1), Port: 3000},
+		{ID: GenerateRandomID(), IP: net.ParseIP("2001:db8::1"), Port: 4000},
+		{ID: GenerateRandomID(), IP: net.IPv4(10, 0, 0, 2), Port: 5000},
+	}
+
+	out, err := DeserializeContacts(SerializeContacts(contacts))
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(out) != len(contacts) {
+		t.Fatalf("Expected %d contacts, but got %d\n", len(contacts), len(out))
+	}
+
+	for i, c := range contacts {
+		if !out[i].ID.Equal(c.ID) || !out[i].IP.Equal(c.IP) || out[i].Port != c.Port {
+			t.Errorf("Expected at index (%d) %s:%d, but got %s:%d\n", i, c.IP, c.Port, out[i].IP, out[i].Port)
+		}
+	}
+
+	truncated := SerializeContacts(contacts)
+	if _, err := DeserializeContacts(truncated[:len(truncated)-1]); err == nil {
+		t.Errorf("Expected an error for a truncated list, but got <nil>\n")
+	}
+}
+
+func TestFullFindNodeReplyFits(t *testing.T) {
+	contacts := make([]Contact, K)
+	for i := range contacts {
+		contacts[i] = generateRandomContact()
+	}
+
+	out, err := DeserializeContacts(encodeContacts(contacts, maxPayloadSize))
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(out) != K {
+		t.Errorf("Expected %d contacts, but got %d\n", K, len(out))
+	}
+}
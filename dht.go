@@ -1,61 +1,242 @@
 package gokad
 
-import "net"
+import (
+	"net"
+	"sync"
+	"time"
+)
 
 const MessageSize = 800
 
-const k = 20
+// DefaultRPCTimeout is how long a remote node is given to respond to a single RPC
+const DefaultRPCTimeout = 2 * time.Second
+
+// DefaultRefreshInterval is how long a bucket may go without a lookup before it is refreshed
+const DefaultRefreshInterval = time.Hour
+
+// DefaultValueTTL is how long a stored value is kept before it expires
+const DefaultValueTTL = 24 * time.Hour
+
+// DefaultRepublishInterval is how often values published through Put are published again
+const DefaultRepublishInterval = 24 * time.Hour
+
+// DefaultReplicateInterval is how often stored values are replicated to the k closest contacts
+const DefaultReplicateInterval = time.Hour
+
+// DefaultMaxValues is how many of the values stored under a key are returned by FindValue
+const DefaultMaxValues = 20
+
+// DefaultExpireInterval is how often expired values are evicted in the background
+const DefaultExpireInterval = time.Minute
 
 type DHTConfig struct {
 	ID ID
+	// IDBits is the length of the random id generated if neither ID nor RoutingTable
+	// is set, rounded up to whole bytes. Defaults to BITS. Use BITS256 for networks
+	// whose keys are SHA-256 hashes, see IDFromKey.
+	// All nodes of a network must use ids of the same length
+	IDBits int
+	// K is the number of contacts every bucket holds, a lookup returns and a value is
+	// replicated to. Defaults to K and must not exceed MaxK.
+	// It is ignored if RoutingTable is set, the routing table's K is used instead
+	K int
+	// Alpha is the number of requests a lookup keeps in flight. Defaults to ALPHA
+	Alpha int
+	// RoutingTable is used instead of an empty one. Use ReadRoutingTable to
+	// restore a snapshot. If ID is not set, the routing table's id is used
 	RoutingTable *RoutingTable
+	// Transport carries the RPCs of the DHT. Without one the DHT can only be used locally
+	Transport Transport
+	// RPCTimeout bounds every RPC sent by the DHT. Defaults to DefaultRPCTimeout
+	RPCTimeout time.Duration
+	// RefreshInterval is how long a bucket may go without a lookup before it
+	// is refreshed in the background. Defaults to DefaultRefreshInterval
+	RefreshInterval time.Duration
+	// ValueTTL is how long a value is kept after it was stored. Values received from
+	// other nodes are kept for less the further this node is from their key.
+	// Defaults to DefaultValueTTL
+	ValueTTL time.Duration
+	// ExpireInterval is how often expired values are evicted in the background.
+	// Defaults to DefaultExpireInterval
+	ExpireInterval time.Duration
+	// RepublishInterval is how often values published through Put are published again
+	// in the background. Defaults to DefaultRepublishInterval
+	RepublishInterval time.Duration
+	// ReplicateInterval is how often stored values are replicated to the k closest contacts
+	// in the background. Defaults to DefaultReplicateInterval
+	ReplicateInterval time.Duration
+	// Store holds the values stored at this node. Defaults to a MemoryStore
+	Store Store
+	// MaxValueSize is the largest value this node publishes or accepts.
+	// Defaults to and is capped at the largest value that fits into a single
+	// STORE request, which is MaxValueSize for ids of SIZE bytes
+	MaxValueSize int
+	// MaxValues is how many of the values stored under a key FindValue returns.
+	// Defaults to DefaultMaxValues
+	MaxValues int
 }
 
-type Value struct{
-	Host net.IP
-	Port int
-}
-
-type values map[string]Value
-
 type DHT struct {
-	ID           ID
+	ID ID
+	// mu guards the published values and the heads being pinged.
+	// The routing table and the store guard themselves
+	mu           sync.Mutex
 	routingTable *RoutingTable
-	storedValues values
+	k            int
+	alpha        int
+	store        Store
+	// published holds the values published through Put
+	published  map[string]Record
+	transport  Transport
+	rpcTimeout time.Duration
+	// pinging holds the bucket heads that are being pinged to make room for a new contact
+	pinging           map[string]bool
+	refreshInterval   time.Duration
+	valueTTL          time.Duration
+	expireInterval    time.Duration
+	republishInterval time.Duration
+	replicateInterval time.Duration
+	maxValueSize      int
+	maxValues         int
+	closeOnce         sync.Once
+	expireOnce        sync.Once
+	done              chan struct{}
 }
 
 func NewDHT() *DHT {
-	id := GenerateRandomID()
-	routing := NewRoutingTable(id)
-
-	return &DHT{
-		ID:           id,
-		routingTable: routing,
-		storedValues: make(values),
-
-	}
+	return DHTFrom(DHTConfig{})
 }
 
+// DHTFrom returns a DHT configured by config.
+// It panics if ID, IDBits and RoutingTable ask for ids of different lengths or K exceeds MaxK
 func DHTFrom(config DHTConfig) *DHT {
+	checkConfig(config)
+
 	var id ID
 	var routing *RoutingTable
-	if config.ID == nil {
-		id = GenerateRandomID()
-	} else {
+	if config.ID != nil {
 		id = config.ID
+	} else if config.RoutingTable != nil {
+		id = config.RoutingTable.ID()
+	} else if config.IDBits > 0 {
+		id = GenerateRandomIDWithBits(config.IDBits)
+	} else {
+		id = GenerateRandomID()
 	}
 
-	if config.RoutingTable == nil {
-		routing = NewRoutingTable(id)
-	} else {
+	if config.RoutingTable != nil {
 		routing = config.RoutingTable
+	} else if config.K > 0 {
+		routing = NewRoutingTableWithK(id, config.K)
+	} else {
+		routing = NewRoutingTable(id)
+	}
+
+	dht := &DHT{
+		ID:                id,
+		routingTable:      routing,
+		k:                 routing.K(),
+		alpha:             config.Alpha,
+		store:             config.Store,
+		published:         make(map[string]Record),
+		transport:         config.Transport,
+		rpcTimeout:        config.RPCTimeout,
+		pinging:           make(map[string]bool),
+		refreshInterval:   config.RefreshInterval,
+		valueTTL:          config.ValueTTL,
+		expireInterval:    config.ExpireInterval,
+		republishInterval: config.RepublishInterval,
+		replicateInterval: config.ReplicateInterval,
+		maxValueSize:      config.MaxValueSize,
+		maxValues:         config.MaxValues,
+		done:              make(chan struct{}),
+	}
+
+	if dht.alpha <= 0 {
+		dht.alpha = ALPHA
+	}
+
+	if dht.rpcTimeout <= 0 {
+		dht.rpcTimeout = DefaultRPCTimeout
+	}
+
+	if dht.refreshInterval <= 0 {
+		dht.refreshInterval = DefaultRefreshInterval
+	}
+
+	if dht.valueTTL <= 0 {
+		dht.valueTTL = DefaultValueTTL
+	}
+
+	if dht.expireInterval <= 0 {
+		dht.expireInterval = DefaultExpireInterval
+	}
+
+	if dht.republishInterval <= 0 {
+		dht.republishInterval = DefaultRepublishInterval
+	}
+
+	if dht.replicateInterval <= 0 {
+		dht.replicateInterval = DefaultReplicateInterval
 	}
 
-	return &DHT {
-		ID: id,
-		routingTable: routing,
-		storedValues: make(values),
+	if limit := valueSizeLimit(len(id)); dht.maxValueSize <= 0 || dht.maxValueSize > limit {
+		dht.maxValueSize = limit
 	}
+
+	if dht.maxValues <= 0 {
+		dht.maxValues = DefaultMaxValues
+	}
+
+	if dht.store == nil {
+		dht.store = NewMemoryStore()
+	}
+
+	if dht.transport != nil {
+		dht.transport.Handle(dht.handleRequest)
+		dht.startExpiring()
+		go dht.refreshLoop()
+		go dht.republishLoop()
+		go dht.replicateLoop()
+	}
+
+	return dht
+}
+
+// checkConfig panics if config asks for ids of different lengths
+func checkConfig(config DHTConfig) {
+	lengths := make([]int, 0, 3)
+	if config.ID != nil {
+		lengths = append(lengths, len(config.ID))
+	}
+
+	if config.RoutingTable != nil {
+		lengths = append(lengths, len(config.RoutingTable.ID()))
+	}
+
+	if config.IDBits > 0 {
+		lengths = append(lengths, (config.IDBits+7)/8)
+	}
+
+	for _, n := range lengths {
+		if n != lengths[0] {
+			panic("gokad: ID, IDBits and RoutingTable of DHTConfig ask for ids of different lengths")
+		}
+	}
+}
+
+// Close stops the background processes of the DHT and closes its transport.
+// A DHT runs background processes if it has a transport or once it stored a value
+func (dht *DHT) Close() error {
+	var err error
+	dht.closeOnce.Do(func() {
+		close(dht.done)
+		if dht.transport != nil {
+			err = dht.transport.Close()
+		}
+	})
+
+	return err
 }
 
 func (dht *DHT) RoutingTable() *RoutingTable {
@@ -68,21 +249,74 @@ func (dht *DHT) GetAlphaNodes(alpha int, id ID) []Contact {
 
 // RPC
 func (dht *DHT) FindNode(id ID) []Contact {
-	return dht.GetAlphaNodes(k, id)
+	return dht.GetAlphaNodes(dht.k, id)
 }
 
-func (dht *DHT) Store(key ID, ip net.IP, port int) {
-	dht.storedValues[key.String()] = Value{
-		Host: ip,
-		Port: port,
+// Store stores the host port record ip:port under key for the value ttl
+func (dht *DHT) Store(key ID, ip net.IP, port int) error {
+	return dht.StoreValue(key, HostPort{Host: ip, Port: port}.Value())
+}
+
+// StoreValue stores v under key for the value ttl, with this node as its publisher.
+// It replaces the value this node stored under key before
+func (dht *DHT) StoreValue(key ID, v Value) error {
+	if err := dht.checkID(key); err != nil {
+		return err
+	}
+
+	if len(v) > dht.maxValueSize {
+		return ErrValueTooLarge
+	}
+
+	return dht.storeValue(key, Provider{Publisher: dht.ID, Value: v}, dht.valueTTL)
+}
+
+// FindValue returns up to max values of the values stored under key, the ones that expire
+// last first. If there are none, the k closest contacts to key are returned instead
+func (dht *DHT) FindValue(key ID) ([]Contact, []Provider) {
+	records, err := dht.store.Get(key)
+	if err != nil || len(records) == 0 {
+		return dht.GetAlphaNodes(dht.k, key), nil
+	}
+
+	for i := 1; i < len(records); i++ {
+		for j := i; j > 0 && records[j].Expires.After(records[j-1].Expires); j-- {
+			records[j], records[j-1] = records[j-1], records[j]
+		}
+	}
+
+	if len(records) > dht.maxValues {
+		records = records[:dht.maxValues]
+	}
+
+	out := make([]Provider, len(records))
+	now := time.Now()
+	for i, r := range records {
+		out[i] = r.Provider()
+		out[i].TTL = r.Expires.Sub(now)
 	}
+
+	return nil, out
 }
 
-func (dht *DHT) FindValue(key ID) ([]Contact, Value) {
-	v, ok := dht.storedValues[key.String()]
-	if ok {
-		return nil, v
+// checkID returns ErrInvalidIDLength unless id has the same length as the id of the DHT
+func (dht *DHT) checkID(id ID) error {
+	if len(id) != len(dht.ID) {
+		return ErrInvalidIDLength
 	}
 
-	return dht.GetAlphaNodes(k, key), Value{}
+	return nil
+}
+
+func (dht *DHT) storeValue(key ID, pr Provider, ttl time.Duration) error {
+	dht.startExpiring()
+	now := time.Now()
+
+	return dht.store.Put(Record{
+		Key:        key,
+		Publisher:  pr.Publisher,
+		Value:      pr.Value,
+		Expires:    now.Add(ttl),
+		Replicated: now,
+	})
 }
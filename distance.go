@@ -9,8 +9,12 @@ func (d Distance) String() string {
 }
 
 func (d Distance) GetBitAt(index uint) int {
-	if index >= BITS {
-		index = 159
+	if len(d) == 0 {
+		return 0
+	}
+
+	if bits := uint(len(d) * 8); index >= bits {
+		index = bits - 1
 	}
 
 	// find in what byte index the index falls
@@ -26,7 +30,11 @@ func (d Distance) GetBitAt(index uint) int {
 }
 
 func (d Distance) Equal(other Distance) bool {
-	for i := 0; i < SIZE; i++ {
+	if len(d) != len(other) {
+		return false
+	}
+
+	for i := 0; i < len(d); i++ {
 		if d[i] != other[i] {
 			return false
 		}
@@ -0,0 +1,48 @@
+package gokad
+
+import "time"
+
+// maxTTLHalvings bounds how often the ttl of a received value is halved
+const maxTTLHalvings = 16
+
+// ttlFor returns how long a value received for key is kept. Nodes that are not among
+// the k closest to key mostly receive it through the caching of value lookups. To avoid
+// over-caching, the value ttl is halved for every contact beyond k that is closer to key
+// than this node
+// @Source: Kademlia: A Peer-to-peer Information System Based on the XOR Metric
+// https://pdos.csail.mit.edu/~petar/papers/maymounkov-kademlia-lncs.pdf
+func (dht *DHT) ttlFor(key ID) time.Duration {
+	excess := dht.routingTable.countCloser(key, dht.ID) - dht.k
+	if excess <= 0 {
+		return dht.valueTTL
+	}
+
+	if excess > maxTTLHalvings {
+		excess = maxTTLHalvings
+	}
+
+	return dht.valueTTL >> uint(excess)
+}
+
+// startExpiring starts the expire loop unless it is running already. A DHT without a transport
+// starts it once the first value is stored, so DHTs that never store a value need not be closed
+func (dht *DHT) startExpiring() {
+	dht.expireOnce.Do(func() {
+		go dht.expireLoop()
+	})
+}
+
+// expireLoop evicts expired values every expire interval until the DHT is closed
+func (dht *DHT) expireLoop() {
+	ticker := time.NewTicker(dht.expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			dht.store.Expire(time.Now())
+		case <-dht.done:
+			return
+		}
+	}
+}
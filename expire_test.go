@@ -0,0 +1,96 @@
+package gokad
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestStoredValueExpires(t *testing.T) {
+	dht := DHTFrom(DHTConfig{ValueTTL: 20 * time.Millisecond})
+	key := GenerateRandomID()
+	dht.Store(key, net.IPv4(10, 0, 0, 1), 4000)
+
+	if contacts, _ := dht.FindValue(key); contacts != nil {
+		t.Fatalf("Expected value to be found before it expired\n")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if contacts, _ := dht.FindValue(key); contacts == nil {
+		t.Errorf("Expected value not to be found after it expired\n")
+	}
+}
+
+func TestExpireEvictsExpiredValues(t *testing.T) {
+	dht := DHTFrom(DHTConfig{ValueTTL: time.Hour})
+	dht.Store(GenerateRandomID(), net.IPv4(10, 0, 0, 1), 4000)
+	dht.storeValue(GenerateRandomID(), Provider{Publisher: dht.ID, Value: HostPort{Host: net.IPv4(10, 0, 0, 2), Port: 4000}.Value()}, time.Millisecond)
+
+	evicted, err := dht.store.Expire(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if evicted != 1 {
+		t.Errorf("Expected %d value to be evicted, but got %d\n", 1, evicted)
+	}
+
+	if left := dht.store.(*MemoryStore).Len(); left != 1 {
+		t.Errorf("Expected %d value to be left, but got %d\n", 1, left)
+	}
+}
+
+func TestBackgroundExpire(t *testing.T) {
+	// a local DHT without a transport
+	dht := DHTFrom(DHTConfig{
+		ValueTTL:       time.Millisecond,
+		ExpireInterval: 10 * time.Millisecond,
+	})
+	defer dht.Close()
+
+	dht.Store(GenerateRandomID(), net.IPv4(10, 0, 0, 1), 4000)
+
+	waitFor(t, func() bool {
+		return dht.store.(*MemoryStore).Len() == 0
+	})
+}
+
+func TestLocalDHTExpiresLazily(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		NewDHT()
+	}
+
+	if after := runtime.NumGoroutine(); after-before >= 10 {
+		t.Errorf("Expected no goroutines to be started, but got %d more\n", after-before)
+	}
+}
+
+func TestTTLIsReducedForDistantKeys(t *testing.T) {
+	dht := NewDHT()
+	for i := 0; i < 100; i++ {
+		dht.RoutingTable().Add(generateRandomContact())
+	}
+
+	// no contact is closer to our own id than we are
+	if ttl := dht.ttlFor(dht.ID); ttl != DefaultValueTTL {
+		t.Errorf("Expected ttl %s, but got %s\n", DefaultValueTTL, ttl)
+	}
+
+	// every contact is closer to the key furthest away from us
+	far := make(ID, SIZE)
+	for i := range far {
+		far[i] = ^dht.ID[i]
+	}
+
+	excess := dht.routingTable.countCloser(far, dht.ID) - K
+	if excess <= 0 {
+		t.Fatalf("Expected more than %d closer contacts, but got %d\n", K, excess+K)
+	}
+
+	if ttl := dht.ttlFor(far); ttl >= DefaultValueTTL {
+		t.Errorf("Expected ttl to be less than %s, but got %s\n", DefaultValueTTL, ttl)
+	}
+}
@@ -0,0 +1,344 @@
+package gokad
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Errors
+var ErrCorruptStore = errors.New("Corrupt Store File")
+
+// errTornEntry is returned for an entry cut short by a crash while it was appended
+var errTornEntry = errors.New("Torn Store Entry")
+
+// fileStoreVersion is the version of the file format written by FileStore
+const fileStoreVersion = 1
+
+// minCompactEntries is the number of entries the log has to hold before it is compacted
+const minCompactEntries = 1024
+
+// Log entry operations
+const (
+	opPut byte = iota + 1
+	opDelete
+	opReplicated
+)
+
+// File format (version 1). The file is a log of the changes made to the store.
+// All integers are big endian, times are unix nanoseconds.
+//
+//   header:     1 byte version
+//   entry:      1 byte operation <- 1 byte id length n <- key (n) <- publisher (n) <- operation data
+//   put:        2 bytes value length l <- l bytes value <- 8 bytes expires <- 8 bytes replicated
+//   delete:     no data
+//   replicated: 8 bytes replicated
+
+// FileStore is a Store that keeps its records in memory and appends every change to a log file,
+// so they survive restarts. Once most entries of the log are outdated, the log is compacted by
+// writing the live records to a temporary file that atomically replaces the log
+type FileStore struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	entries int
+	records records
+}
+
+// OpenFileStore opens the store kept at path. If the file does not exist the store starts out
+// empty and the file is created on the first change. Records that expired in the meantime are dropped,
+// as is an entry cut short by a crash
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, records: make(records)}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.load(b); err != nil {
+		return nil, err
+	}
+
+	s.records.expire(time.Now())
+
+	// start out with a log holding only the live records
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) Put(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records.put(r)
+
+	return s.append(appendPut(nil, r))
+}
+
+func (s *FileStore) Get(key ID) ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.records.get(key, time.Now()), nil
+}
+
+func (s *FileStore) Delete(key ID, publisher ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.records.delete(key, publisher) {
+		return nil
+	}
+
+	return s.append(appendEntry(nil, opDelete, key, publisher))
+}
+
+func (s *FileStore) MarkReplicated(key ID, publisher ID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.records.markReplicated(key, publisher, at) {
+		return nil
+	}
+
+	return s.append(appendTime(appendEntry(nil, opReplicated, key, publisher), at))
+}
+
+func (s *FileStore) Iterate(fn func(r Record) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.records.iterate(time.Now(), fn)
+
+	return nil
+}
+
+// Expire removes the expired records from memory only. Their entries are left in the log,
+// which drops them when it is compacted or read again
+func (s *FileStore) Expire(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := s.records.expire(now)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	return removed, s.compactIfOutdated()
+}
+
+// Close closes the log file. Later changes reopen it
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+// append writes the entry to the end of the log, creating the log if it does not exist yet
+func (s *FileStore) append(entry []byte) error {
+	if s.file == nil {
+		f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		if info.Size() == 0 {
+			entry = append([]byte{fileStoreVersion}, entry...)
+		}
+
+		s.file = f
+	}
+
+	if _, err := s.file.Write(entry); err != nil {
+		return err
+	}
+	s.entries++
+
+	return s.compactIfOutdated()
+}
+
+// compactIfOutdated compacts the log once it holds more than twice as many entries as there are records
+func (s *FileStore) compactIfOutdated() error {
+	if s.entries < minCompactEntries || s.entries <= 2*s.records.len() {
+		return nil
+	}
+
+	return s.compact()
+}
+
+// compact writes the live records to a temporary file, syncs it and moves it over the log
+func (s *FileStore) compact() error {
+	out := []byte{fileStoreVersion}
+	for _, byPublisher := range s.records {
+		for _, r := range byPublisher {
+			out = appendPut(out, r)
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// the next change reopens the new log
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	s.entries = s.records.len()
+
+	return nil
+}
+
+// load replays the entries of the log b
+func (s *FileStore) load(b []byte) error {
+	if len(b) < 1 || b[0] != fileStoreVersion {
+		return ErrCorruptStore
+	}
+
+	r := bytes.NewReader(b[1:])
+	for r.Len() > 0 {
+		err := s.replay(r)
+		if errors.Is(err, errTornEntry) {
+			// only the last entry can be cut short
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.entries++
+	}
+
+	return nil
+}
+
+// replay reads a single entry from r and applies it to the records
+func (s *FileStore) replay(r *bytes.Reader) error {
+	op, _ := r.ReadByte()
+	if op < opPut || op > opReplicated {
+		return ErrCorruptStore
+	}
+
+	n, err := r.ReadByte()
+	if err != nil {
+		return errTornEntry
+	}
+	if n == 0 {
+		return ErrCorruptStore
+	}
+
+	key := make(ID, n)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return errTornEntry
+	}
+
+	publisher := make(ID, n)
+	if _, err := io.ReadFull(r, publisher); err != nil {
+		return errTornEntry
+	}
+
+	switch op {
+	case opDelete:
+		s.records.delete(key, publisher)
+	case opReplicated:
+		at, err := readTime(r)
+		if err != nil {
+			return errTornEntry
+		}
+		s.records.markReplicated(key, publisher, at)
+	case opPut:
+		length, err := readUint16(r)
+		if err != nil {
+			return errTornEntry
+		}
+
+		v := make(Value, length)
+		if _, err := io.ReadFull(r, v); err != nil {
+			return errTornEntry
+		}
+
+		expires, err := readTime(r)
+		if err != nil {
+			return errTornEntry
+		}
+
+		replicated, err := readTime(r)
+		if err != nil {
+			return errTornEntry
+		}
+
+		s.records.put(Record{
+			Key:        key,
+			Publisher:  publisher,
+			Value:      v,
+			Expires:    expires,
+			Replicated: replicated,
+		})
+	}
+
+	return nil
+}
+
+func appendEntry(out []byte, op byte, key ID, publisher ID) []byte {
+	out = append(out, op, byte(len(key)))
+	out = append(out, key...)
+
+	return append(out, publisher...)
+}
+
+func appendPut(out []byte, r Record) []byte {
+	out = appendEntry(out, opPut, r.Key, r.Publisher)
+	out = appendUint16(out, uint16(len(r.Value)))
+	out = append(out, r.Value...)
+	out = appendTime(out, r.Expires)
+
+	return appendTime(out, r.Replicated)
+}
@@ -2,39 +2,105 @@ package gokad
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 )
 
+// Errors
+var ErrInvalidIDLength = errors.New("Invalid ID Length")
+var ErrInvalidIDHex = errors.New("Invalid ID Hex")
+
 type NodeID interface {
 	GetBitAt(index uint) int
 }
 
-// From returns an ID based on the provided hex id string
+// From returns an ID based on the provided hex id string.
+// ErrInvalidIDHex is returned if hexID is not valid hex and ErrInvalidIDLength
+// if it does not hold a 160 (SIZE) or 256 (SIZE256) bit id
 func From(hexID string) (ID, error) {
+	id, err := decodeHexID(hexID)
+	if err != nil {
+		return nil, err
+	}
 
-	src := []byte(hexID)
-	dst := make([]byte, hex.DecodedLen(len(src)))
+	if len(id) != SIZE && len(id) != SIZE256 {
+		return nil, ErrInvalidIDLength
+	}
 
-	n, err := hex.Decode(dst, src)
+	return id, nil
+}
 
+// ParseID returns the id of bits bits, rounded up to whole bytes, held by the hex string hexID.
+// ErrInvalidIDHex is returned if hexID is not valid hex and ErrInvalidIDLength if the id has another length
+func ParseID(hexID string, bits int) (ID, error) {
+	id, err := decodeHexID(hexID)
 	if err != nil {
 		return nil, err
 	}
 
-	id := ID(dst[:n])
+	return IDFromBytes(id, bits)
+}
+
+// IDFromBytes returns a copy of b as an id of bits bits, rounded up to whole bytes.
+// ErrInvalidIDLength is returned if b has another length
+func IDFromBytes(b []byte, bits int) (ID, error) {
+	if bits <= 0 || len(b) != (bits+7)/8 {
+		return nil, ErrInvalidIDLength
+	}
+
+	id := make(ID, len(b))
+	copy(id, b)
 
 	return id, nil
 }
 
+func decodeHexID(hexID string) (ID, error) {
+	src := []byte(hexID)
+	dst := make([]byte, hex.DecodedLen(len(src)))
+
+	n, err := hex.Decode(dst, src)
+
+	if err != nil {
+		return nil, ErrInvalidIDHex
+	}
+
+	return ID(dst[:n]), nil
+}
+
 // GenerateRandomID generates a random ID of length SIZE (20)
 func GenerateRandomID() ID {
-	id := make([]byte, 20)
+	return GenerateRandomIDWithBits(BITS)
+}
+
+// GenerateRandomIDWithBits generates a random ID of bits bits, rounded up to whole bytes
+func GenerateRandomIDWithBits(bits int) ID {
+	id := make([]byte, (bits+7)/8)
 	rand.Read(id)
 
 	return ID(id)
 
 }
 
+// IDFromKey hashes an arbitrary key into the space of 256 bit ids.
+// The id is the SHA-256 hash of data
+func IDFromKey(data []byte) ID {
+	sum := sha256.Sum256(data)
+
+	return ID(sum[:])
+}
+
+// IDFromKeyWithBits hashes an arbitrary key into the space of ids of bits bits, rounded up to
+// whole bytes. The id is the SHA-256 hash of data truncated to the id length.
+// ErrInvalidIDLength is returned if bits exceeds BITS256
+func IDFromKeyWithBits(data []byte, bits int) (ID, error) {
+	if bits <= 0 || bits > BITS256 {
+		return nil, ErrInvalidIDLength
+	}
+
+	return IDFromKey(data)[:(bits+7)/8], nil
+}
+
 // GenerateID generates a regular 20 byte ID
 // and copies each byte of in to the ID.
 // example:
@@ -45,27 +111,43 @@ func GenerateRandomID() ID {
 // This function can be used for tests to build up mock networks
 func GenerateID(in []byte) ID {
 	out := make(ID, 20)
-	for i, b := range in {
-		out[i] = b
-	}
+	copy(out, in)
 
 	return out
 }
 
-// SIZE describes how many bytes in an id
+// SIZE describes how many bytes in an id by default
 const SIZE = 20
 
-// BITS describes how many bits in an id
-const BITS = 160
+// BITS describes how many bits in an id by default
+const BITS = SIZE * 8
+
+// SIZE256 describes how many bytes in a 256 bit id, the size of a SHA-256 hash
+const SIZE256 = sha256.Size
+
+// BITS256 describes how many bits in a 256 bit id
+const BITS256 = SIZE256 * 8
 
+// ID identifies nodes and keys. IDs of any length work, but all ids compared
+// to each other should have the same length. Where they do not, the shorter
+// id is treated as if it was padded with zero bytes, so no method panics
 type ID []byte
 
 func (id ID) String() string {
 	return hex.EncodeToString(id)
 }
 
+// Bits returns the length of the id in bits
+func (id ID) Bits() int {
+	return len(id) * 8
+}
+
 func (id ID) Equal(other ID) bool {
-	for i := 0; i < SIZE; i++ {
+	if len(id) != len(other) {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
 		if id[i] != other[i] {
 			return false
 		}
@@ -76,10 +158,10 @@ func (id ID) Equal(other ID) bool {
 
 // DistanceTo calculates the distance to 'other' base on XOR metric
 func (id ID) DistanceTo(other ID) Distance {
-	res := make(Distance, 20)
+	res := make(Distance, len(id))
 
-	for i := 0; i < SIZE; i++ {
-		res[i] = id[i] ^ other[i]
+	for i := 0; i < len(id); i++ {
+		res[i] = id[i] ^ byteAt(other, i)
 	}
 
 	return res
@@ -88,9 +170,9 @@ func (id ID) DistanceTo(other ID) Distance {
 // CompareDistanceTo returns 0 if first and second are equally far away
 // returns 1 if first is closer and return -1 if second is closer
 func (id ID) CompareDistanceTo(id1 ID, id2 ID) int {
-	for i := 0; i < SIZE; i++ {
-		b1 := id[i] ^ id1[i]
-		b2 := id[i] ^ id2[i]
+	for i := 0; i < len(id); i++ {
+		b1 := id[i] ^ byteAt(id1, i)
+		b2 := id[i] ^ byteAt(id2, i)
 
 		if b1 < b2 {
 			return 1
@@ -105,10 +187,14 @@ func (id ID) CompareDistanceTo(id1 ID, id2 ID) int {
 }
 
 // GetBitAt returns the bit at the specified index
-// If index >= BITS (160) the last bit is returned
+// If index is beyond the length of the id the last bit is returned
 func (id ID) GetBitAt(index uint) int {
-	if index >= BITS {
-		index = 159
+	if len(id) == 0 {
+		return 0
+	}
+
+	if bits := uint(id.Bits()); index >= bits {
+		index = bits - 1
 	}
 
 	// find in what byte index the index falls
@@ -123,3 +209,64 @@ func (id ID) GetBitAt(index uint) int {
 	return 0
 
 }
+
+// ID160 is a 160 bit id backed by an array. Unlike ID it always has the right
+// length and is comparable, so it can be used as a map key
+type ID160 [SIZE]byte
+
+// NewID160 returns a copy of id as an ID160.
+// ErrInvalidIDLength is returned if id is not SIZE bytes long
+func NewID160(id ID) (ID160, error) {
+	var out ID160
+	if len(id) != SIZE {
+		return out, ErrInvalidIDLength
+	}
+
+	copy(out[:], id)
+
+	return out, nil
+}
+
+// ID returns the id as an ID
+func (id ID160) ID() ID {
+	return ID(id[:])
+}
+
+func (id ID160) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// ID256 is a 256 bit id backed by an array. Unlike ID it always has the right
+// length and is comparable, so it can be used as a map key
+type ID256 [SIZE256]byte
+
+// NewID256 returns a copy of id as an ID256.
+// ErrInvalidIDLength is returned if id is not SIZE256 bytes long
+func NewID256(id ID) (ID256, error) {
+	var out ID256
+	if len(id) != SIZE256 {
+		return out, ErrInvalidIDLength
+	}
+
+	copy(out[:], id)
+
+	return out, nil
+}
+
+// ID returns the id as an ID
+func (id ID256) ID() ID {
+	return ID(id[:])
+}
+
+func (id ID256) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// byteAt returns the byte of b at index i, or 0 if b is shorter
+func byteAt(b []byte, i int) byte {
+	if i >= len(b) {
+		return 0
+	}
+
+	return b[i]
+}
@@ -1,6 +1,7 @@
 package gokad
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 )
@@ -88,23 +89,18 @@ func TestGenerateIDAndInsert(t *testing.T) {
 
 	cases := []struct{
 		IN ID
-		OUT int
 	}{
 		{
 			IN: GenerateID([]byte{0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,2}),
-			OUT: 1,
 		},
 		{
 			IN: GenerateID([]byte{0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0}),
-			OUT: 0,
 		},
 		{
 			IN: GenerateID([]byte{0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,128}),
-			OUT: 7,
 		},
 		{
 			IN: GenerateID([]byte{128,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0}),
-			OUT: 159,
 		},
 	}
 
@@ -115,8 +111,148 @@ func TestGenerateIDAndInsert(t *testing.T) {
 			t.Fatalf("Case %d failed. Expected error to be nil, but got %s\n", i, err)
 		}
 
-		if index != c.OUT {
-			t.Fatalf("Case %d failed. Expected index to be %d, but got %d\n", i, c.OUT, index)
+		// a new routing table holds every contact in its single bucket
+		if index != 0 {
+			t.Fatalf("Case %d failed. Expected index to be %d, but got %d\n", i, 0, index)
 		}
 	}
 }
+
+func TestIDFromKey(t *testing.T) {
+	id := IDFromKey([]byte("hello"))
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	if id.String() != expected || id.Bits() != BITS256 {
+		t.Errorf("Expected %s, but got %s\n", expected, id)
+	}
+
+	truncated, err := IDFromKeyWithBits([]byte("hello"), BITS)
+	if err != nil || truncated.String() != expected[:2*SIZE] {
+		t.Errorf("Expected %s, but got %s\n", expected[:2*SIZE], truncated)
+	}
+
+	if _, err := IDFromKeyWithBits([]byte("hello"), BITS256+8); !errors.Is(err, ErrInvalidIDLength) {
+		t.Errorf("Expected error %s, but got %v\n", ErrInvalidIDLength, err)
+	}
+}
+
+func TestIDWith256Bits(t *testing.T) {
+	id := make(ID, SIZE256)
+	id[SIZE256-1] = 1
+
+	if bit := id.GetBitAt(BITS256 - 1); bit != 1 {
+		t.Errorf("Expected bit to be %d, but got %d\n", 1, bit)
+	}
+
+	// beyond the last bit the last bit is returned
+	if bit := id.GetBitAt(BITS256 + 8); bit != 1 {
+		t.Errorf("Expected bit to be %d, but got %d\n", 1, bit)
+	}
+
+	closer := make(ID, SIZE256)
+	closer[SIZE256-1] = 3
+	further := make(ID, SIZE256)
+	further[SIZE256-1] = 4
+
+	if d := id.DistanceTo(closer); len(d) != SIZE256 || d[SIZE256-1] != 2 {
+		t.Errorf("Expected distance %d in the last byte, but got %s\n", 2, d)
+	}
+
+	if id.CompareDistanceTo(closer, further) != 1 {
+		t.Errorf("Expected %s to be closer to %s than %s\n", closer, id, further)
+	}
+
+	if id.Equal(id[:SIZE]) {
+		t.Errorf("Expected ids of different lengths not to be equal\n")
+	}
+}
+
+func TestFromRejectsMalformedIDs(t *testing.T) {
+	cases := []struct {
+		in  string
+		err error
+	}{
+		{"0c204d39600fddd3f1f20ca8007e91c7d0293b1", ErrInvalidIDHex},
+		{"zz204d39600fddd3f1f20ca8007e91c7d0293b1c", ErrInvalidIDHex},
+		{"0c204d", ErrInvalidIDLength},
+		{"", ErrInvalidIDLength},
+		{"0c204d39600fddd3f1f20ca8007e91c7d0293b1c00", ErrInvalidIDLength},
+	}
+
+	for _, c := range cases {
+		if _, err := From(c.in); !errors.Is(err, c.err) {
+			t.Errorf("Expected error %s for %q, but got %v\n", c.err, c.in, err)
+		}
+	}
+
+	if id, err := From(IDFromKey([]byte("hello")).String()); err != nil || len(id) != SIZE256 {
+		t.Errorf("Expected a 256 bit id to be parsed, but got %v\n", err)
+	}
+}
+
+func TestParseID(t *testing.T) {
+	hexID := "0c204d39600fddd3f1f20ca8007e91c7d0293b1c"
+
+	id, err := ParseID(hexID, BITS)
+	if err != nil || id.String() != hexID {
+		t.Errorf("Expected %s, but got %s %v\n", hexID, id, err)
+	}
+
+	if _, err := ParseID(hexID, BITS256); !errors.Is(err, ErrInvalidIDLength) {
+		t.Errorf("Expected error %s, but got %v\n", ErrInvalidIDLength, err)
+	}
+
+	b := []byte(id)
+	copied, _ := IDFromBytes(b, BITS)
+	b[0] = 0xff
+	if copied[0] == 0xff {
+		t.Errorf("Expected IDFromBytes to copy its input\n")
+	}
+}
+
+func TestArrayBackedIDs(t *testing.T) {
+	id := GenerateRandomID()
+
+	a, err := NewID160(id)
+	if err != nil || !a.ID().Equal(id) || a.String() != id.String() {
+		t.Errorf("Expected %s, but got %s %v\n", id, a, err)
+	}
+
+	// array backed ids are comparable
+	seen := map[ID160]bool{a: true}
+	if b, _ := NewID160(id); !seen[b] {
+		t.Errorf("Expected %s to be found by value\n", b)
+	}
+
+	if _, err := NewID160(id[:10]); !errors.Is(err, ErrInvalidIDLength) {
+		t.Errorf("Expected error %s, but got %v\n", ErrInvalidIDLength, err)
+	}
+
+	key := IDFromKey([]byte("hello"))
+	if b, err := NewID256(key); err != nil || !b.ID().Equal(key) {
+		t.Errorf("Expected %s, but got %s %v\n", key, b, err)
+	}
+
+	if _, err := NewID256(id); !errors.Is(err, ErrInvalidIDLength) {
+		t.Errorf("Expected error %s, but got %v\n", ErrInvalidIDLength, err)
+	}
+}
+
+func TestShortIDsDoNotPanic(t *testing.T) {
+	id := GenerateRandomID()
+	short := ID{1, 2}
+
+	if id.Equal(short) || short.Equal(id) {
+		t.Errorf("Expected ids of different lengths not to be equal\n")
+	}
+
+	if d := id.DistanceTo(short); len(d) != SIZE || d[2] != id[2] {
+		t.Errorf("Expected missing bytes to count as zero, but got %s\n", d)
+	}
+
+	id.CompareDistanceTo(short, nil)
+	short.CompareDistanceTo(id, GenerateRandomID())
+	ID{}.GetBitAt(3)
+	Distance{}.GetBitAt(3)
+	compareDistance(id.DistanceTo(id), Distance{})
+}
@@ -2,47 +2,198 @@ package gokad
 
 import (
 	"bytes"
+	"crypto/rand"
 	"errors"
-	"math"
+	"fmt"
+	"sync"
+	"time"
 )
 
-// MaxCapacity is a system defined MaxCapacity of each kbucket
-const MaxCapacity = 20
+// MaxCapacity is the default capacity of each kbucket
+const MaxCapacity = K
+
+// MaxFailures is the number of consecutive failed RPCs after which a contact is removed from its kbucket
+const MaxFailures = 3
+
+// MaxReplacements is the maximum number of contacts kept in the replacement cache of each kbucket
+const MaxReplacements = 20
 
 // Errors
-const ErrBucketAtCapacity = "Bucket at Capacity"
-const ErrContactExists = "Contact Exists Already"
-const ErrBucketIndexOutOfBounds = "Bucket Index Out Of Bounds"
-const ErrNoHeadFound = "No Bucket Head Found"
+var ErrBucketAtCapacity = errors.New("Bucket at Capacity")
+var ErrContactExists = errors.New("Contact Exists Already")
+var ErrBucketIndexOutOfBounds = errors.New("Bucket Index Out Of Bounds")
+var ErrNoHeadFound = errors.New("No Bucket Head Found")
+
+// BucketFullError is returned when a contact does not fit into a full bucket. It carries the
+// head of the bucket, which is pinged to decide whether the contact takes its place, and the
+// index of the bucket. It matches ErrBucketAtCapacity, so errors.Is(err, ErrBucketAtCapacity) holds
+type BucketFullError struct {
+	Head  Contact
+	Index int
+}
 
-// KBucket is a bucket that contains k (MaxCapacity) contacts
+func (e *BucketFullError) Error() string {
+	return fmt.Sprintf("%s: bucket %d", ErrBucketAtCapacity, e.Index)
+}
+
+// Is reports whether target is ErrBucketAtCapacity
+func (e *BucketFullError) Is(target error) bool {
+	return target == ErrBucketAtCapacity
+}
+
+// KBucket is a bucket that contains k (MaxCapacity by default) contacts.
+// Contacts that do not fit into a full bucket are kept in a replacement cache
+// of at most MaxReplacements contacts, ordered from least to most recently seen.
+// A bucket covers all ids whose first depth bits equal those of its prefix.
+// All exported methods are safe for concurrent use
 type KBucket struct {
-	Index int
-	head  *Contact
-	tail  *Contact
-	size  int
+	mu           sync.RWMutex
+	index        int
+	capacity     int
+	prefix       ID
+	depth        int
+	head         *Contact
+	tail         *Contact
+	size         int
+	replacements []Contact
+	lastLookup   time.Time
 }
 
-// NewKBucket returns a new KBucket with Index index
+// NewKBucket returns a new KBucket with index index and capacity MaxCapacity
+// that covers the whole space of SIZE byte ids
 func NewKBucket(index int) *KBucket {
+	return newKBucket(index, MaxCapacity, SIZE)
+}
+
+// newKBucket returns a new KBucket with index index and capacity k that covers the whole space of size byte ids
+func newKBucket(index int, k int, size int) *KBucket {
 	return &KBucket{
-		Index: index,
+		index:      index,
+		capacity:   k,
+		prefix:     make(ID, size),
+		lastLookup: time.Now(),
 	}
 }
 
+// Index returns the position of the bucket in its routing table. It changes when a bucket
+// closer to our own id is split
+func (b *KBucket) Index() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.index
+}
+
+// setIndex moves the bucket to index
+func (b *KBucket) setIndex(index int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.index = index
+}
+
+// Capacity returns the number of contacts the bucket holds at most
+func (b *KBucket) Capacity() int {
+	return b.capacity
+}
+
+// Depth returns the number of leading bits shared by all ids the bucket covers
+func (b *KBucket) Depth() int {
+	return b.depth
+}
+
+// covers returns true if id falls into the range of the bucket
+func (b *KBucket) covers(id ID) bool {
+	for i := 0; i < b.depth; i++ {
+		if id.GetBitAt(uint(i)) != b.prefix.GetBitAt(uint(i)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// distanceTo returns the smallest distance between target and any id the bucket covers
+func (b *KBucket) distanceTo(target ID) Distance {
+	d := make(Distance, len(b.prefix))
+	for i := 0; i < b.depth; i++ {
+		if target.GetBitAt(uint(i)) != b.prefix.GetBitAt(uint(i)) {
+			d[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+
+	return d
+}
+
+// randomID returns a random id in the range of the bucket
+func (b *KBucket) randomID() ID {
+	id := make(ID, len(b.prefix))
+	rand.Read(id)
+
+	for i := 0; i < b.depth; i++ {
+		mask := byte(0x80 >> uint(i%8))
+		id[i/8] = id[i/8]&^mask | b.prefix[i/8]&mask
+	}
+
+	return id
+}
+
+// split divides the bucket into two buckets one bit deeper. The first one covers the ids
+// with a 0 and the second one the ids with a 1 at bit depth. Contacts and replacements
+// keep their order and both buckets inherit the last lookup
+func (b *KBucket) split() (*KBucket, *KBucket) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	children := [2]*KBucket{}
+	for bit := range children {
+		prefix := make(ID, len(b.prefix))
+		copy(prefix, b.prefix)
+		if bit == 1 {
+			prefix[b.depth/8] |= 0x80 >> uint(b.depth%8)
+		}
+
+		children[bit] = &KBucket{
+			capacity:   b.capacity,
+			prefix:     prefix,
+			depth:      b.depth + 1,
+			lastLookup: b.lastLookup,
+		}
+	}
+
+	b.walk(func(c Contact) bool {
+		c.next = nil
+		children[c.ID.GetBitAt(uint(b.depth))].add(c)
+		return false
+	})
+
+	for _, c := range b.replacements {
+		child := children[c.ID.GetBitAt(uint(b.depth))]
+		child.replacements = append(child.replacements, c)
+	}
+
+	return children[0], children[1]
+}
+
 // Insert attempts to insert a new Contact into the KBucket
 // Adding a new node to the bucket contains the following steps:
-//  1. If Bucket contains less than MaxCapacity nodes and node does not already exist - add node to tail
+//  1. If Bucket contains less than its capacity nodes and node does not already exist - add node to tail
 //  2. If Bucket contains node already, the node is moved to the tail of the list
-//  3. If Bucket contains MaxCapacity, the node at the head is pinged. If it replies, the current head is moved
+//  3. If Bucket is at capacity, the node at the head is pinged. If it replies, the current head is moved
 //     to the tail and the contact is not added. If it does not reply, the head is discarded and the contact is
 //     added to the tail
+// In case 3 the contact is put into the replacement cache and the head is returned along with
+// a *BucketFullError so it can be pinged
 // @Source: Implementation of the Kademlia Distributed Hash Table by Bruno Spori Semester Thesis
 // https://pub.tik.ee.ethz.ch/students/2006-So/SA-2006-19.pdf
 ///
 func (b *KBucket) Insert(c Contact) (Contact, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	// bucket is completely empty. just initialize it
-	if b.IsEmpty() {
+	if b.isEmpty() {
+		b.dropReplacement(c.ID)
 		b.add(c)
 		return c, nil
 	}
@@ -51,20 +202,38 @@ func (b *KBucket) Insert(c Contact) (Contact, error) {
 	// 2. Node already exists: Move the node to the tail
 	if index > -1 {
 		b.moveToTail(index)
-		return c, errors.New(ErrContactExists)
+		b.tail.failures = 0
+		if c.LastSeen.After(b.tail.LastSeen) {
+			b.tail.LastSeen = c.LastSeen
+		}
+		return c, ErrContactExists
 		// 1. Bucket does not contain node and is not at capacity: add it to the tail
-	} else if index < 0 && b.size < MaxCapacity {
+	} else if index < 0 && b.size < b.capacity {
+		b.dropReplacement(c.ID)
 		b.add(c)
 		return c, nil
 	}
 
-	return *b.head, errors.New(ErrBucketAtCapacity)
+	b.addReplacement(c)
+
+	head := *b.head
+	head.next = nil
+
+	return head, &BucketFullError{Head: head, Index: b.index}
 
 }
 
 // Walk traverses the bucket list calling the walkFn for each contact in the bucket
-// if need to return early from the walk, return true from the walkFn
+// if need to return early from the walk, return true from the walkFn.
+// The bucket is locked for reading during the walk, so walkFn must not modify it
 func (b *KBucket) Walk(walkFn func(c Contact) bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	b.walk(walkFn)
+}
+
+func (b *KBucket) walk(walkFn func(c Contact) bool) {
 	head := b.head
 	if head == nil {
 		return
@@ -81,30 +250,143 @@ func (b *KBucket) Walk(walkFn func(c Contact) bool) {
 
 // IsEmpty returns true if the bucket is empty. false otherwise
 func (b *KBucket) IsEmpty() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.isEmpty()
+}
+
+func (b *KBucket) isEmpty() bool {
 	return b.head == nil && b.tail == nil
 }
 
 // Size returns the size of the bucket
 func (b *KBucket) Size() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	return b.size
 }
 
 // Head returns the head of the bucket list's contacts
 func (b *KBucket) Head() Contact {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	return *b.head
 }
 
 // Tail returns the tail of the bucket list's contacts
 func (b *KBucket) Tail() Contact {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	return *b.tail
 }
 
+// Fail records a failed RPC to the contact with the given id and returns its number of
+// consecutive failures. Once a contact reaches MaxFailures it is removed from the bucket.
+// Fail returns 0 if the bucket does not contain such a contact
+func (b *KBucket) Fail(id ID) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for current := b.head; current != nil; current = current.next {
+		if !current.ID.Equal(id) {
+			continue
+		}
+
+		current.failures++
+		failures := current.failures
+		if failures >= MaxFailures {
+			b.remove(id)
+		}
+
+		return failures
+	}
+
+	return 0
+}
+
+// LastLookup returns the last time a lookup was performed for an id in the range of the bucket
+func (b *KBucket) LastLookup() time.Time {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.lastLookup
+}
+
+// touch records that a lookup for an id in the range of the bucket was performed at t
+func (b *KBucket) touch(t time.Time) {
+	b.mu.Lock()
+	b.lastLookup = t
+	b.mu.Unlock()
+}
+
+// contacted records that an RPC was sent to the contact with the given id at t
+func (b *KBucket) contacted(id ID, t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for current := b.head; current != nil; current = current.next {
+		if current.ID.Equal(id) {
+			current.LastContacted = t
+			return
+		}
+	}
+}
+
+// Replacements returns the contacts in the replacement cache
+// ordered from least to most recently seen
+func (b *KBucket) Replacements() []Contact {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	out := make([]Contact, len(b.replacements))
+	copy(out, b.replacements)
+
+	return out
+}
+
+// addReplacement puts c at the end of the replacement cache.
+// If the cache is full the least recently seen contact is dropped
+func (b *KBucket) addReplacement(c Contact) {
+	b.dropReplacement(c.ID)
+	c.next = nil
+	b.replacements = append(b.replacements, c)
+
+	if len(b.replacements) > MaxReplacements {
+		b.replacements = b.replacements[1:]
+	}
+}
+
+func (b *KBucket) dropReplacement(id ID) {
+	for i, r := range b.replacements {
+		if r.ID.Equal(id) {
+			b.replacements = append(b.replacements[:i], b.replacements[i+1:]...)
+			return
+		}
+	}
+}
+
+// promoteReplacement moves the most recently seen contact of the replacement cache to the tail of the bucket
+func (b *KBucket) promoteReplacement() {
+	n := len(b.replacements)
+	if n == 0 || b.size >= b.capacity {
+		return
+	}
+
+	c := b.replacements[n-1]
+	b.replacements = b.replacements[:n-1]
+	b.add(c)
+}
+
 // indexOf returns the index of the contact
 // if the contact is not found it returns -1
 func (b *KBucket) indexOf(c Contact) int {
 	index := -1
 	var found bool
-	b.Walk(func(contact Contact) bool {
+	b.walk(func(contact Contact) bool {
 		index++
 		if c.ID.Equal(contact.ID) {
 			found = true
@@ -123,7 +405,7 @@ func (b *KBucket) indexOf(c Contact) int {
 
 func (b *KBucket) add(c Contact) {
 	b.size++
-	if b.IsEmpty() {
+	if b.isEmpty() {
 		b.head = &c
 		b.tail = &c
 	} else {
@@ -133,86 +415,97 @@ func (b *KBucket) add(c Contact) {
 
 }
 
+// Remove unlinks the contact with the given id from the bucket and promotes
+// the most recently seen contact of the replacement cache in its place.
+// It returns false if the bucket does not contain such a contact
+func (b *KBucket) Remove(id ID) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.remove(id)
+}
+
+func (b *KBucket) remove(id ID) bool {
+	var prev *Contact
+	for current := b.head; current != nil; current = current.next {
+		if !current.ID.Equal(id) {
+			prev = current
+			continue
+		}
+
+		if prev == nil {
+			b.head = current.next
+		} else {
+			prev.next = current.next
+		}
+
+		if b.tail == current {
+			b.tail = prev
+		}
+
+		current.next = nil
+		b.size--
+		b.promoteReplacement()
+
+		return true
+	}
+
+	return false
+}
+
 func (b *KBucket) moveToTail(index int) error {
-	if index >= b.size {
-		return errors.New(ErrBucketIndexOutOfBounds)
+	if index < 0 || index >= b.size {
+		return ErrBucketIndexOutOfBounds
 	}
 
 	head := b.head
 	if head == nil {
-		return errors.New(ErrNoHeadFound)
+		return ErrNoHeadFound
 	}
 
-	if head.next == nil || head.next.next == nil {
+	// already at the tail
+	if index == b.size-1 {
 		return nil
 	}
 
-	if index == 0 {
-		temp := b.head
-		b.head = b.head.next
-		b.tail = temp
-		return nil
+	var prev *Contact
+	target := head
+	for i := 0; i < index; i++ {
+		prev = target
+		target = target.next
 	}
 
-	counter := 1
-	var slow *Contact
-	var target *Contact
-	var fast *Contact
-
-	slow = head
-	target = head.next
-	fast = target.next
-
-	for {
-		if counter == index {
-			slow.next = fast
-			target.next = nil
-			b.tail = target
-			return nil
-		}
-
-		counter++
-		slow = target
-		target = fast
-		fast = fast.next
-
-		if fast == nil && counter != index {
-			return errors.New(ErrBucketIndexOutOfBounds)
-		}
+	if prev == nil {
+		b.head = target.next
+	} else {
+		prev.next = target.next
 	}
 
+	target.next = nil
+	b.tail.next = target
+	b.tail = target
+
+	return nil
 }
 
 func (b *KBucket) getXClosestContacts(x int, targetID ID) []Contact {
-	distances := make([]Distance, b.Size())
-	index := 0
-	distanceMap := make(map[string]Contact)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 
-	b.Walk(func(c Contact) bool {
-		delta := c.ID.DistanceTo(targetID)
-		distanceMap[delta.String()] = c
-		distances[index] = delta
-		index++
+	out := make([]Contact, 0, x)
+	if x <= 0 {
+		return out
+	}
+
+	b.walk(func(c Contact) bool {
+		out = insertClosest(out, c, targetID, x)
 		return false
 	})
 
-	// now sort the list by distance
-	sort(distances)
-	bounds := math.Min(float64(x), float64(len(distances)))
-	xClosestDeltas := distances[:int(bounds)]
-	out := make([]Contact, 0)
-
-	for _, d := range xClosestDeltas {
-		contact, ok := distanceMap[d.String()]
-		if ok {
-			out = append(out, contact)
-		}
-	}
-
 	return out
 }
 
-func (b KBucket) String() string {
+func (b *KBucket) String() string {
 	buf := new(bytes.Buffer)
 
 	b.Walk(func(c Contact) bool {
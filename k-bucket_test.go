@@ -1,6 +1,7 @@
 package gokad
 
 import (
+	"errors"
 	"log"
 	"math/rand"
 	"net"
@@ -101,6 +102,158 @@ func TestMoveNToTail(t *testing.T) {
 
 }
 
+func TestMoveToTailKeepsAllContacts(t *testing.T) {
+	for index := 0; index < 4; index++ {
+		bucket := getPreSetBucket()
+		before := make([]Contact, 0)
+		bucket.Walk(func(c Contact) bool {
+			before = append(before, c)
+			return false
+		})
+
+		bucket.moveToTail(index)
+
+		after := make([]Contact, 0)
+		bucket.Walk(func(c Contact) bool {
+			after = append(after, c)
+			return false
+		})
+
+		if len(after) != bucket.Size() {
+			t.Fatalf("Expected %d contacts after moving index %d, but walked %d\n", bucket.Size(), index, len(after))
+		}
+
+		if !bucket.Tail().ID.Equal(before[index].ID) {
+			t.Errorf("Expected Tail to be %s but got %s\n", before[index].ID, bucket.Tail().ID)
+		}
+	}
+}
+
+func TestRemoveFromBucket(t *testing.T) {
+	cases := []int{0, 1, 3}
+
+	for _, index := range cases {
+		bucket := getPreSetBucket()
+		contacts := make([]Contact, 0)
+		bucket.Walk(func(c Contact) bool {
+			contacts = append(contacts, c)
+			return false
+		})
+
+		if !bucket.Remove(contacts[index].ID) {
+			t.Fatalf("Expected contact at index %d to be removed\n", index)
+		}
+
+		if bucket.Size() != 3 || bucket.indexOf(contacts[index]) != -1 {
+			t.Errorf("Expected contact at index %d to be gone, but got %s\n", index, bucket)
+		}
+
+		c := generateRandomContact()
+		bucket.add(c)
+		if !bucket.Tail().ID.Equal(c.ID) {
+			t.Errorf("Expected Tail to be %s but got %s\n", c.ID, bucket.Tail().ID)
+		}
+	}
+
+	bucket := getPreSetBucket()
+	if bucket.Remove(GenerateRandomID()) {
+		t.Errorf("Expected unknown contact not to be removed\n")
+	}
+}
+
+func TestInsertIntoFullBucketFillsReplacementCache(t *testing.T) {
+	bucket := NewKBucket(0)
+	for i := 0; i < MaxCapacity; i++ {
+		bucket.Insert(generateRandomContact())
+	}
+
+	candidates := make([]Contact, MaxReplacements+1)
+	for i := range candidates {
+		candidates[i] = generateRandomContact()
+		head, err := bucket.Insert(candidates[i])
+		if !errors.Is(err, ErrBucketAtCapacity) {
+			t.Fatalf("Expected error %s, but got %v\n", ErrBucketAtCapacity, err)
+		}
+
+		if !head.ID.Equal(bucket.Head().ID) {
+			t.Errorf("Expected head %s to be returned, but got %s\n", bucket.Head().ID, head.ID)
+		}
+	}
+
+	replacements := bucket.Replacements()
+	if len(replacements) != MaxReplacements {
+		t.Fatalf("Expected %d replacements, but got %d\n", MaxReplacements, len(replacements))
+	}
+
+	// the least recently seen candidate was dropped
+	if !replacements[0].ID.Equal(candidates[1].ID) {
+		t.Errorf("Expected oldest replacement to be %s, but got %s\n", candidates[1].ID, replacements[0].ID)
+	}
+
+	// seeing a cached contact again makes it the most recent one
+	bucket.Insert(candidates[1])
+	replacements = bucket.Replacements()
+	if !replacements[len(replacements)-1].ID.Equal(candidates[1].ID) {
+		t.Errorf("Expected newest replacement to be %s, but got %s\n", candidates[1].ID, replacements[len(replacements)-1].ID)
+	}
+}
+
+func TestRemovePromotesMostRecentReplacement(t *testing.T) {
+	bucket := NewKBucket(0)
+	for i := 0; i < MaxCapacity; i++ {
+		bucket.Insert(generateRandomContact())
+	}
+
+	older := generateRandomContact()
+	newer := generateRandomContact()
+	bucket.Insert(older)
+	bucket.Insert(newer)
+
+	bucket.Remove(bucket.Head().ID)
+
+	if bucket.Size() != MaxCapacity {
+		t.Errorf("Expected size to be %d but got %d\n", MaxCapacity, bucket.Size())
+	}
+
+	if !bucket.Tail().ID.Equal(newer.ID) {
+		t.Errorf("Expected Tail to be %s but got %s\n", newer.ID, bucket.Tail().ID)
+	}
+
+	replacements := bucket.Replacements()
+	if len(replacements) != 1 || !replacements[0].ID.Equal(older.ID) {
+		t.Errorf("Expected only %s to be left in the replacement cache, but got %v\n", older.ID, replacements)
+	}
+}
+
+func TestFailRemovesContactAfterMaxFailures(t *testing.T) {
+	bucket := getPreSetBucket()
+	c := bucket.Head()
+
+	for i := 1; i < MaxFailures; i++ {
+		if n := bucket.Fail(c.ID); n != i {
+			t.Errorf("Expected %d failures, but got %d\n", i, n)
+		}
+	}
+
+	// seeing the contact again resets its failures
+	bucket.Insert(c)
+	if n := bucket.Fail(c.ID); n != 1 {
+		t.Errorf("Expected failures to be reset, but got %d\n", n)
+	}
+
+	for i := 1; i < MaxFailures; i++ {
+		bucket.Fail(c.ID)
+	}
+
+	if bucket.indexOf(c) != -1 || bucket.Size() != 3 {
+		t.Errorf("Expected %s to be removed, but got %s\n", c.ID, bucket)
+	}
+
+	if n := bucket.Fail(c.ID); n != 0 {
+		t.Errorf("Expected %d failures for an unknown contact, but got %d\n", 0, n)
+	}
+}
+
 func TestGetXclosestContacts(t *testing.T) {
 	bucket := NewKBucket(0)
 	//c1 := generateContactFrom("C80F741BC1B397C54A54858E4E2A8840B2BC766B")
@@ -141,6 +294,48 @@ func TestGetXclosestContacts(t *testing.T) {
 
 }
 
+func TestSplitBucket(t *testing.T) {
+	bucket := NewKBucket(0)
+	ids := []string{
+		"10" + GenerateRandomID().String()[2:],
+		"90" + GenerateRandomID().String()[2:],
+		"20" + GenerateRandomID().String()[2:],
+		"a0" + GenerateRandomID().String()[2:],
+	}
+
+	for _, id := range ids {
+		bucket.Insert(generateContactFrom(id))
+	}
+	bucket.addReplacement(generateContactFrom("b0" + GenerateRandomID().String()[2:]))
+	bucket.touch(time.Unix(0, 1))
+
+	zero, one := bucket.split()
+
+	if expected := ids[0] + "," + ids[2] + ","; zero.String() != expected {
+		t.Errorf("Expected bucket 0 to be %s, but got %s\n", expected, zero)
+	}
+
+	if expected := ids[1] + "," + ids[3] + ","; one.String() != expected {
+		t.Errorf("Expected bucket 1 to be %s, but got %s\n", expected, one)
+	}
+
+	if len(zero.Replacements()) != 0 || len(one.Replacements()) != 1 {
+		t.Errorf("Expected the replacement to move to bucket 1\n")
+	}
+
+	for _, b := range []*KBucket{zero, one} {
+		if b.Depth() != 1 || !b.LastLookup().Equal(time.Unix(0, 1)) {
+			t.Errorf("Expected depth %d and last lookup %s, but got %d %s\n", 1, time.Unix(0, 1), b.Depth(), b.LastLookup())
+		}
+
+		for i := 0; i < 10; i++ {
+			if id := b.randomID(); !b.covers(id) || zero.covers(id) == one.covers(id) {
+				t.Errorf("Expected %s to fall into a single bucket\n", id)
+			}
+		}
+	}
+}
+
 // Utils
 
 func getPreSetBucket() *KBucket {
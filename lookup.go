@@ -0,0 +1,331 @@
+package gokad
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Errors
+var ErrValueNotFound = errors.New("Value Not Found")
+var ErrNoReplicas = errors.New("No Replicas Stored")
+
+// Lookup performs an iterative node lookup and returns the k closest contacts to target.
+// The lookup starts from the k closest contacts in the routing table and keeps alpha
+// FIND_NODE requests in flight to the closest contacts that have not been queried yet.
+// Every response is merged into a shortlist sorted by distance to target. Contacts
+// that fail to respond are dropped from the shortlist. The lookup terminates once
+// the k closest contacts in the shortlist have all responded.
+// ErrInvalidIDLength is returned if target does not have the length of our own id
+// @Source: Kademlia: A Peer-to-peer Information System Based on the XOR Metric
+// https://pdos.csail.mit.edu/~petar/papers/maymounkov-kademlia-lncs.pdf
+func (dht *DHT) Lookup(ctx context.Context, target ID) ([]Contact, error) {
+	if err := dht.checkID(target); err != nil {
+		return nil, err
+	}
+
+	l := newLookup(dht, target, func(ctx context.Context, c Contact) lookupResult {
+		contacts, err := dht.SendFindNode(ctx, c, target)
+		return lookupResult{contacts: contacts, err: err}
+	})
+
+	return l.run(ctx)
+}
+
+// Get performs an iterative value lookup for key. It works like Lookup but sends
+// FIND_VALUE requests and collects the values returned by the contacts, one per publisher,
+// until values of max values publishers were collected. The values held by this node are
+// returned together with the ones found in the network. The values found in the network
+// are then stored in the background at the closest contact that responded without them,
+// so subsequent lookups for key find them sooner. The copies expire together with the
+// values they were made from
+func (dht *DHT) Get(ctx context.Context, key ID) ([]Provider, error) {
+	if err := dht.checkID(key); err != nil {
+		return nil, err
+	}
+
+	l := newLookup(dht, key, func(ctx context.Context, c Contact) lookupResult {
+		contacts, providers, err := dht.SendFindValue(ctx, c, key)
+		return lookupResult{contacts: contacts, providers: providers, err: err}
+	})
+	l.maxProviders = dht.maxValues
+
+	_, local := dht.FindValue(key)
+	for _, pr := range local {
+		l.publishers[pr.Publisher.String()] = true
+	}
+
+	path, err := l.run(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := append(local, l.providers...)
+	if len(providers) == 0 {
+		return nil, ErrValueNotFound
+	}
+
+	for _, c := range path {
+		if !l.holders[c.ID.String()] {
+			dht.cache(c, key, l.providers)
+			break
+		}
+	}
+
+	return providers, nil
+}
+
+// cache stores the providers under key at c in the background. Each copy is kept
+// as long as the node the provider was returned by keeps it
+func (dht *DHT) cache(c Contact, key ID, providers []Provider) {
+	for _, pr := range providers {
+		if pr.TTL <= 0 {
+			continue
+		}
+
+		go func(pr Provider) {
+			ctx, cancel := dht.closeContext()
+			defer cancel()
+
+			dht.sendStore(ctx, c, key, pr, pr.TTL)
+		}(pr)
+	}
+}
+
+// Put publishes v under key with this node as its publisher. It looks up the k closest contacts to key and
+// sends each of them a STORE request in parallel. The number of contacts that
+// acknowledged the STORE is returned. If none did, ErrNoReplicas is returned.
+// The value is published again every republish interval until the DHT is closed.
+// Values larger than the max value size are rejected with ErrValueTooLarge
+func (dht *DHT) Put(ctx context.Context, key ID, v Value) (int, error) {
+	if err := dht.checkID(key); err != nil {
+		return 0, err
+	}
+
+	if len(v) > dht.maxValueSize {
+		return 0, ErrValueTooLarge
+	}
+
+	dht.mu.Lock()
+	dht.published[key.String()] = Record{Key: key, Publisher: dht.ID, Value: v}
+	dht.mu.Unlock()
+
+	return dht.replicate(ctx, key, Provider{Publisher: dht.ID, Value: v}, dht.valueTTL)
+}
+
+// replicate stores the value of pr under key at the k closest contacts to key for ttl
+func (dht *DHT) replicate(ctx context.Context, key ID, pr Provider, ttl time.Duration) (int, error) {
+	contacts, err := dht.Lookup(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	acks := make(chan error, len(contacts))
+	for _, c := range contacts {
+		go func(c Contact) {
+			acks <- dht.sendStore(ctx, c, key, pr, ttl)
+		}(c)
+	}
+
+	replicas := 0
+	for range contacts {
+		if err := <-acks; err == nil {
+			replicas++
+		}
+	}
+
+	if replicas == 0 {
+		return 0, ErrNoReplicas
+	}
+
+	return replicas, nil
+}
+
+// shortlistEntry is a contact discovered during a lookup
+type shortlistEntry struct {
+	contact   Contact
+	queried   bool
+	responded bool
+}
+
+// lookupResult is the outcome of querying a single contact
+type lookupResult struct {
+	contact   Contact
+	contacts  []Contact
+	providers []Provider
+	err       error
+}
+
+// lookup holds the state of a single iterative lookup.
+// The providers returned by the contacts are collected, one per publisher not seen before,
+// together with the contacts that returned any. Once providers of maxProviders publishers
+// were seen the lookup stops. A maxProviders of 0 does not stop the lookup
+type lookup struct {
+	dht          *DHT
+	target       ID
+	query        func(ctx context.Context, c Contact) lookupResult
+	entries      []*shortlistEntry
+	seen         map[string]bool
+	providers    []Provider
+	publishers   map[string]bool
+	holders      map[string]bool
+	maxProviders int
+}
+
+func newLookup(dht *DHT, target ID, query func(ctx context.Context, c Contact) lookupResult) *lookup {
+	l := &lookup{
+		dht:        dht,
+		target:     target,
+		query:      query,
+		seen:       make(map[string]bool),
+		publishers: make(map[string]bool),
+		holders:    make(map[string]bool),
+	}
+
+	dht.routingTable.touch(target)
+
+	for _, c := range dht.GetAlphaNodes(dht.k, target) {
+		l.add(c)
+	}
+
+	return l
+}
+
+func (l *lookup) run(ctx context.Context) ([]Contact, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan lookupResult)
+	inFlight := 0
+
+	for {
+		for inFlight < l.dht.alpha {
+			e, ok := l.next()
+			if !ok {
+				break
+			}
+
+			e.queried = true
+			inFlight++
+			go func(c Contact) {
+				r := l.query(ctx, c)
+				r.contact = c
+				select {
+				case results <- r:
+				case <-ctx.Done():
+				}
+			}(e.contact)
+		}
+
+		// the k closest contacts have all been queried and answered
+		if inFlight == 0 {
+			return l.closest(), nil
+		}
+
+		select {
+		case r := <-results:
+			inFlight--
+			if r.err != nil {
+				l.remove(r.contact)
+				continue
+			}
+
+			l.responded(r.contact)
+			if len(r.providers) > 0 {
+				l.holders[r.contact.ID.String()] = true
+				l.addProviders(r.providers)
+				if l.maxProviders > 0 && len(l.publishers) >= l.maxProviders {
+					return l.closest(), nil
+				}
+			}
+
+			for _, c := range r.contacts {
+				l.add(c)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// add inserts c into the shortlist keeping it sorted by distance to the target.
+// Contacts that were seen before and the local node are ignored
+func (l *lookup) add(c Contact) {
+	key := c.ID.String()
+	if c.ID.Equal(l.dht.ID) || l.seen[key] {
+		return
+	}
+	l.seen[key] = true
+
+	i := len(l.entries)
+	for i > 0 && l.target.CompareDistanceTo(c.ID, l.entries[i-1].contact.ID) > 0 {
+		i--
+	}
+
+	l.entries = append(l.entries, nil)
+	copy(l.entries[i+1:], l.entries[i:])
+	l.entries[i] = &shortlistEntry{contact: c}
+}
+
+// addProviders collects the providers whose publisher was not seen before
+func (l *lookup) addProviders(providers []Provider) {
+	for _, pr := range providers {
+		key := pr.Publisher.String()
+		if l.publishers[key] {
+			continue
+		}
+
+		l.publishers[key] = true
+		l.providers = append(l.providers, pr)
+	}
+}
+
+// remove drops c from the shortlist. It is not added again if another node returns it
+func (l *lookup) remove(c Contact) {
+	for i, e := range l.entries {
+		if e.contact.ID.Equal(c.ID) {
+			l.entries = append(l.entries[:i], l.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (l *lookup) responded(c Contact) {
+	for _, e := range l.entries {
+		if e.contact.ID.Equal(c.ID) {
+			e.responded = true
+			return
+		}
+	}
+}
+
+// next returns the closest of the k closest entries that has not been queried yet
+func (l *lookup) next() (*shortlistEntry, bool) {
+	for i, e := range l.entries {
+		if i >= l.dht.k {
+			break
+		}
+
+		if !e.queried {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+// closest returns the k closest contacts that responded
+func (l *lookup) closest() []Contact {
+	out := make([]Contact, 0, l.dht.k)
+	for _, e := range l.entries {
+		if len(out) >= l.dht.k {
+			break
+		}
+
+		if e.responded {
+			out = append(out, e.contact)
+		}
+	}
+
+	return out
+}
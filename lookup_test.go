@@ -0,0 +1,505 @@
+package gokad
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLookupFindsKClosest(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, contacts := newMemoryCluster(network, 60, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	target := GenerateRandomID()
+	searcher := nodes[len(nodes)-1]
+
+	out, err := searcher.Lookup(ctx, target)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	expected := bruteForceClosest(contacts, searcher.ID, target, K)
+	if len(out) != len(expected) {
+		t.Fatalf("Expected %d contacts, but got %d\n", len(expected), len(out))
+	}
+
+	for i, c := range expected {
+		if !out[i].ID.Equal(c.ID) {
+			t.Errorf("Expected at index (%d) %s, but got %s\n", i, c.ID, out[i].ID)
+		}
+	}
+}
+
+func TestLookupSkipsUnresponsiveContacts(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	remote, c := newMemoryNode(network)
+	local.RoutingTable().Add(c)
+	local.RoutingTable().Add(generateRandomContact()) // not attached to the network
+
+	out, err := local.Lookup(context.Background(), GenerateRandomID())
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(out) != 1 || !out[0].ID.Equal(remote.ID) {
+		t.Errorf("Expected only %s to be returned, but got %v\n", remote.ID, out)
+	}
+}
+
+func TestLookupWithEmptyRoutingTable(t *testing.T) {
+	local, _ := newMemoryNode(NewMemoryNetwork())
+
+	out, err := local.Lookup(context.Background(), GenerateRandomID())
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(out) != 0 {
+		t.Errorf("Expected no contacts, but got %d\n", len(out))
+	}
+}
+
+// newMemoryCluster creates n nodes with deterministic ids derived from seed.
+// Every node joins through the first node by looking up its own id
+func newMemoryCluster(network *MemoryNetwork, n int, seed int64) ([]*DHT, []Contact) {
+	return newMemoryClusterFrom(network, n, seed, DHTConfig{})
+}
+
+// newMemoryClusterFrom works like newMemoryCluster, creating every node from config
+// with ids of config.IDBits bits
+func newMemoryClusterFrom(network *MemoryNetwork, n int, seed int64, config DHTConfig) ([]*DHT, []Contact) {
+	r := rand.New(rand.NewSource(seed))
+	nodes := make([]*DHT, n)
+	contacts := make([]Contact, n)
+
+	size := SIZE
+	if config.IDBits > 0 {
+		size = (config.IDBits + 7) / 8
+	}
+
+	for i := range nodes {
+		id := make(ID, size)
+		r.Read(id)
+
+		transport := network.NewTransport()
+		config.ID = id
+		config.Transport = transport
+		nodes[i] = DHTFrom(config)
+		contacts[i] = Contact{ID: id, IP: transport.Addr().IP, Port: transport.Addr().Port}
+	}
+
+	for i := 1; i < n; i++ {
+		nodes[i].RoutingTable().Add(contacts[0])
+		nodes[i].Lookup(context.Background(), nodes[i].ID)
+	}
+
+	return nodes, contacts
+}
+
+// bruteForceClosest returns the n closest contacts to target excluding self if it is set
+func bruteForceClosest(contacts []Contact, self ID, target ID, n int) []Contact {
+	out := make([]Contact, 0)
+	for _, c := range contacts {
+		if self != nil && c.ID.Equal(self) {
+			continue
+		}
+
+		i := len(out)
+		for i > 0 && target.CompareDistanceTo(c.ID, out[i-1].ID) > 0 {
+			i--
+		}
+
+		out = append(out, Contact{})
+		copy(out[i+1:], out[i:])
+		out[i] = c
+	}
+
+	if len(out) > n {
+		out = out[:n]
+	}
+
+	return out
+}
+
+func TestGetFindsValueInCluster(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, contacts := newMemoryCluster(network, 40, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := GenerateRandomID()
+	holder := bruteForceClosest(contacts, nil, key, 1)[0]
+	nodes[0].SendStore(ctx, holder, key, net.IPv4(10, 0, 0, 1), 4000)
+
+	searcher := nodes[len(nodes)-1]
+	if searcher.ID.Equal(holder.ID) {
+		searcher = nodes[len(nodes)-2]
+	}
+
+	providers, err := searcher.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	h, _ := ParseHostPort(providers[0].Value)
+	if !h.Host.Equal(net.IPv4(10, 0, 0, 1)) || h.Port != 4000 {
+		t.Errorf("Expected value to be 10.0.0.1:4000, but got %s:%d\n", h.Host, h.Port)
+	}
+}
+
+func TestGetCachesAtClosestNonHolder(t *testing.T) {
+	network := NewMemoryNetwork()
+	searcher, _ := newMemoryNode(network)
+	path, pathContact := newMemoryNode(network)
+	holder, holderContact := newMemoryNode(network)
+
+	// searcher -> path -> holder
+	searcher.RoutingTable().Add(pathContact)
+	path.RoutingTable().Add(holderContact)
+
+	key := GenerateRandomID()
+	holder.Store(key, net.IPv4(10, 0, 0, 1), 4000)
+	searcher.Store(key, net.IPv4(10, 0, 0, 2), 5000)
+
+	providers, err := searcher.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(providers) != 2 {
+		t.Fatalf("Expected %d providers, but got %d\n", 2, len(providers))
+	}
+
+	waitFor(t, func() bool {
+		contacts, _ := path.FindValue(key)
+		return contacts == nil
+	})
+
+	// only the value found in the network is cached, and it expires with the original
+	records, _ := path.store.Get(key)
+	if len(records) != 1 || !records[0].Publisher.Equal(holder.ID) {
+		t.Fatalf("Expected only the value of %s to be cached, but got %v\n", holder.ID, records)
+	}
+
+	original, _ := holder.store.Get(key)
+	if records[0].Expires.After(original[0].Expires.Add(time.Second)) {
+		t.Errorf("Expected cached value to expire by %s, but got %s\n", original[0].Expires, records[0].Expires)
+	}
+}
+
+func TestGetStopsOnceEnoughValuesAreFound(t *testing.T) {
+	network := NewMemoryNetwork()
+	searcher := DHTFrom(DHTConfig{Transport: network.NewTransport(), MaxValues: 1})
+	near := newMemoryNodeWithID(network, GenerateID([]byte{1}))
+	far := newMemoryNodeWithID(network, GenerateID([]byte{128}))
+
+	// far answers only after near did
+	far.transport.Handle(func(from Contact, req Message) (Message, error) {
+		time.Sleep(100 * time.Millisecond)
+		return far.handleRequest(from, req)
+	})
+	searcher.RoutingTable().Add(contactOf(near))
+	searcher.RoutingTable().Add(contactOf(far))
+
+	key := GenerateID([]byte{0})
+	near.Store(key, net.IPv4(10, 0, 0, 1), 4000)
+	far.Store(key, net.IPv4(10, 0, 0, 2), 4000)
+
+	providers, err := searcher.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(providers) != 1 || !providers[0].Publisher.Equal(near.ID) {
+		t.Errorf("Expected only the value of %s, but got %d values\n", near.ID, len(providers))
+	}
+}
+
+func TestGetMergesLocalAndNetworkProviders(t *testing.T) {
+	network := NewMemoryNetwork()
+	searcher, _ := newMemoryNode(network)
+	holder, holderContact := newMemoryNode(network)
+	searcher.RoutingTable().Add(holderContact)
+
+	key := GenerateRandomID()
+	searcher.Store(key, net.IPv4(10, 0, 0, 1), 4000)
+	holder.Store(key, net.IPv4(10, 0, 0, 2), 4001)
+
+	// the searcher's own value held by the holder is returned once
+	own := Provider{Publisher: searcher.ID, Value: HostPort{Host: net.IPv4(10, 0, 0, 1), Port: 4000}.Value()}
+	holder.storeValue(key, own, time.Hour)
+
+	providers, err := searcher.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(providers) != 2 {
+		t.Fatalf("Expected %d providers, but got %d\n", 2, len(providers))
+	}
+
+	found := false
+	for _, pr := range providers {
+		found = found || pr.Publisher.Equal(holder.ID)
+	}
+
+	if !found {
+		t.Errorf("Expected the value published by %s to be found\n", holder.ID)
+	}
+}
+
+func TestGetValueNotFound(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, _ := newMemoryCluster(network, 10, 3)
+
+	_, err := nodes[1].Get(context.Background(), GenerateRandomID())
+	if !errors.Is(err, ErrValueNotFound) {
+		t.Errorf("Expected error %s, but got %v\n", ErrValueNotFound, err)
+	}
+}
+
+func TestPutReplicatesToKClosest(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, contacts := newMemoryCluster(network, 40, 4)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := GenerateRandomID()
+	publisher := nodes[0]
+
+	n, err := publisher.Put(ctx, key, HostPort{Host: net.IPv4(10, 0, 0, 1), Port: 4000}.Value())
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if n != K {
+		t.Errorf("Expected %d replicas, but got %d\n", K, n)
+	}
+
+	for _, c := range bruteForceClosest(contacts, publisher.ID, key, K) {
+		for _, node := range nodes {
+			if !node.ID.Equal(c.ID) {
+				continue
+			}
+
+			if got, _ := node.FindValue(key); got != nil {
+				t.Errorf("Expected %s to hold a replica\n", c.ID)
+			}
+		}
+	}
+
+	// the publisher going away does not take the value with it
+	publisher.transport.Close()
+
+	providers, err := nodes[1].Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	h, _ := ParseHostPort(providers[0].Value)
+	if h.Port != 4000 {
+		t.Errorf("Expected port to be %d, but got %d\n", 4000, h.Port)
+	}
+}
+
+func TestPutWithoutContacts(t *testing.T) {
+	local, _ := newMemoryNode(NewMemoryNetwork())
+
+	n, err := local.Put(context.Background(), GenerateRandomID(), HostPort{Host: net.IPv4(10, 0, 0, 1), Port: 4000}.Value())
+	if !errors.Is(err, ErrNoReplicas) {
+		t.Errorf("Expected error %s, but got %v\n", ErrNoReplicas, err)
+	}
+
+	if n != 0 {
+		t.Errorf("Expected %d replicas, but got %d\n", 0, n)
+	}
+}
+
+func TestGetReturnsEveryProvider(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, _ := newMemoryCluster(network, 20, 7)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := GenerateRandomID()
+	for i, publisher := range nodes[:3] {
+		if _, err := publisher.Put(ctx, key, HostPort{Host: net.IPv4(10, 0, 0, 1), Port: 4000 + i}.Value()); err != nil {
+			t.Fatalf("Expected error to be nil, but got %s\n", err)
+		}
+	}
+
+	providers, err := nodes[len(nodes)-1].Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(providers) != 3 {
+		t.Fatalf("Expected %d providers, but got %d\n", 3, len(providers))
+	}
+
+	for i, publisher := range nodes[:3] {
+		found := false
+		for _, pr := range providers {
+			h, _ := ParseHostPort(pr.Value)
+			if pr.Publisher.Equal(publisher.ID) && h.Port == 4000+i {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("Expected a value published by %s\n", publisher.ID)
+		}
+	}
+}
+
+func TestDHTUsesKOfRoutingTable(t *testing.T) {
+	restored := DHTFrom(DHTConfig{K: 10, RoutingTable: NewRoutingTableWithK(GenerateRandomID(), 5)})
+	if restored.k != 5 {
+		t.Errorf("Expected k to be %d, but got %d\n", 5, restored.k)
+	}
+}
+
+func TestInconsistentConfigPanics(t *testing.T) {
+	configs := []DHTConfig{
+		{K: MaxK + 1},
+		{ID: GenerateRandomIDWithBits(BITS256), RoutingTable: NewRoutingTable(GenerateRandomID())},
+		{ID: GenerateRandomID(), IDBits: BITS256},
+		{IDBits: BITS256, RoutingTable: NewRoutingTable(GenerateRandomID())},
+	}
+
+	for i, config := range configs {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Case %d failed. Expected DHTFrom to panic\n", i)
+				}
+			}()
+
+			DHTFrom(config)
+		}()
+	}
+}
+
+func TestLookupWithSmallK(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, _ := newMemoryClusterFrom(network, 30, 8, DHTConfig{K: 4, Alpha: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	target := GenerateRandomID()
+	searcher := nodes[len(nodes)-1]
+
+	out, err := searcher.Lookup(ctx, target)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	// with buckets this small the routing tables are too sparse to always find the closest contacts
+	if len(out) != 4 {
+		t.Fatalf("Expected %d contacts, but got %d\n", 4, len(out))
+	}
+
+	for i := 1; i < len(out); i++ {
+		if target.CompareDistanceTo(out[i-1].ID, out[i].ID) <= 0 {
+			t.Errorf("Expected contacts to be sorted at index (%d)\n", i)
+		}
+	}
+
+	for _, b := range searcher.RoutingTable().Buckets() {
+		if b.Capacity() != 4 || b.Size() > 4 {
+			t.Errorf("Expected bucket %d to hold at most %d contacts, but got %d\n", b.Index(), 4, b.Size())
+		}
+	}
+}
+
+func TestClusterWith256BitIDs(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, contacts := newMemoryClusterFrom(network, 30, 9, DHTConfig{IDBits: BITS256})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	target := IDFromKey([]byte("some content"))
+	searcher := nodes[len(nodes)-1]
+
+	out, err := searcher.Lookup(ctx, target)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	expected := bruteForceClosest(contacts, searcher.ID, target, K)
+	if len(out) != len(expected) || !out[0].ID.Equal(expected[0].ID) {
+		t.Fatalf("Expected the %d closest contacts to %s\n", len(expected), target)
+	}
+
+	if _, err := nodes[0].Put(ctx, target, Value("a value")); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	providers, err := searcher.Get(ctx, target)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if string(providers[0].Value) != "a value" || !providers[0].Publisher.Equal(nodes[0].ID) {
+		t.Errorf("Expected the value published by %s, but got %q\n", nodes[0].ID, providers[0].Value)
+	}
+}
+
+func TestNodesWithOtherIDLengthAreRejected(t *testing.T) {
+	network := NewMemoryNetwork()
+	local := DHTFrom(DHTConfig{Transport: network.NewTransport(), IDBits: 256})
+	remote, c := newMemoryNode(network)
+
+	if err := local.SendPing(context.Background(), c); err == nil {
+		t.Errorf("Expected ping to a node with %d bit ids to fail\n", BITS)
+	}
+
+	if err := remote.SendPing(context.Background(), contactOf(local)); err == nil {
+		t.Errorf("Expected ping from a node with %d bit ids to fail\n", BITS)
+	}
+
+	if len(local.RoutingTable().ClosestContacts(local.ID, K)) != 0 || len(remote.RoutingTable().ClosestContacts(remote.ID, K)) != 0 {
+		t.Errorf("Expected no contacts to be added\n")
+	}
+}
+
+func TestKeysOfOtherLengthAreRejected(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, contacts := newMemoryCluster(network, 5, 10)
+	local := nodes[1]
+	ctx := context.Background()
+
+	for _, key := range []ID{nil, {1, 2, 3}, IDFromKey([]byte("hello"))} {
+		if _, err := local.Lookup(ctx, key); err != ErrInvalidIDLength {
+			t.Errorf("Lookup: Expected error %s, but got %v\n", ErrInvalidIDLength, err)
+		}
+
+		if _, err := local.Get(ctx, key); err != ErrInvalidIDLength {
+			t.Errorf("Get: Expected error %s, but got %v\n", ErrInvalidIDLength, err)
+		}
+
+		if _, err := local.Put(ctx, key, Value("a value")); err != ErrInvalidIDLength {
+			t.Errorf("Put: Expected error %s, but got %v\n", ErrInvalidIDLength, err)
+		}
+
+		if err := local.StoreValue(key, Value("a value")); err != ErrInvalidIDLength {
+			t.Errorf("StoreValue: Expected error %s, but got %v\n", ErrInvalidIDLength, err)
+		}
+
+		if _, _, err := local.SendFindValue(ctx, contacts[0], key); err != ErrInvalidIDLength {
+			t.Errorf("SendFindValue: Expected error %s, but got %v\n", ErrInvalidIDLength, err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package gokad
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Errors
+var ErrHostUnreachable = errors.New("Host Unreachable")
+var ErrNoResponse = errors.New("No Response")
+
+// MemoryNetwork connects MemoryTransports living in the same process.
+// Messages are encoded to their wire format and passed through channels, so
+// whole networks of DHTs can be run in tests without opening any sockets
+type MemoryNetwork struct {
+	mu    sync.Mutex
+	nodes map[string]*MemoryTransport
+	port  int
+}
+
+// NewMemoryNetwork returns an empty in-memory network
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		nodes: make(map[string]*MemoryTransport),
+	}
+}
+
+// NewTransport attaches a new transport to the network.
+// Every transport is assigned a unique port on 127.0.0.1
+func (n *MemoryNetwork) NewTransport() *MemoryTransport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.port++
+	t := &MemoryTransport{
+		network: n,
+		addr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: n.port},
+		inbox:   make(chan envelope),
+		done:    make(chan struct{}),
+	}
+
+	n.nodes[t.addr.String()] = t
+	go t.serve()
+
+	return t
+}
+
+func (n *MemoryNetwork) lookup(ip net.IP, port int) (*MemoryTransport, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t, ok := n.nodes[net.JoinHostPort(ip.String(), strconv.Itoa(port))]
+	return t, ok
+}
+
+func (n *MemoryNetwork) remove(t *MemoryTransport) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.nodes, t.addr.String())
+}
+
+// envelope carries an encoded request to the inbox of a MemoryTransport
+type envelope struct {
+	from  *net.UDPAddr
+	data  []byte
+	reply chan []byte
+}
+
+// MemoryTransport is a Transport attached to a MemoryNetwork.
+// Incoming requests are served one at a time in the order they arrive
+type MemoryTransport struct {
+	network *MemoryNetwork
+	addr    *net.UDPAddr
+	inbox   chan envelope
+	mu      sync.Mutex
+	handler Handler
+	done    chan struct{}
+}
+
+// Addr returns the address the transport is reachable at within its network
+func (t *MemoryTransport) Addr() *net.UDPAddr {
+	return t.addr
+}
+
+// Handle registers the handler incoming requests are delivered to
+func (t *MemoryTransport) Handle(h Handler) {
+	t.mu.Lock()
+	t.handler = h
+	t.mu.Unlock()
+}
+
+// Close detaches the transport from its network
+func (t *MemoryTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.done:
+		return nil
+	default:
+		close(t.done)
+	}
+
+	t.network.remove(t)
+
+	return nil
+}
+
+// Call sends req to c and waits for the matching response.
+// It fails immediately if no transport is attached at c's address
+func (t *MemoryTransport) Call(ctx context.Context, c Contact, req Message) (Message, error) {
+	b, err := req.Encode()
+	if err != nil {
+		return Message{}, err
+	}
+
+	dst, ok := t.network.lookup(c.IP, c.Port)
+	if !ok {
+		return Message{}, ErrHostUnreachable
+	}
+
+	e := envelope{
+		from:  t.addr,
+		data:  b,
+		reply: make(chan []byte, 1),
+	}
+
+	select {
+	case dst.inbox <- e:
+	case <-dst.done:
+		return Message{}, ErrHostUnreachable
+	case <-t.done:
+		return Message{}, ErrTransportClosed
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+
+	select {
+	case data := <-e.reply:
+		if data == nil {
+			return Message{}, ErrNoResponse
+		}
+
+		res, err := DecodeMessage(data)
+		if err != nil {
+			return Message{}, err
+		}
+
+		if !checkResponse(c, req, res) {
+			return Message{}, ErrUnexpectedResponse
+		}
+
+		return res, nil
+	case <-t.done:
+		return Message{}, ErrTransportClosed
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+func (t *MemoryTransport) serve() {
+	for {
+		select {
+		case e := <-t.inbox:
+			e.reply <- t.handle(e)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// handle returns the encoded response to e or nil if there is none
+func (t *MemoryTransport) handle(e envelope) []byte {
+	t.mu.Lock()
+	h := t.handler
+	t.mu.Unlock()
+
+	if h == nil {
+		return nil
+	}
+
+	req, err := DecodeMessage(e.data)
+	if err != nil || req.Response {
+		return nil
+	}
+
+	res, err := h(Contact{ID: req.SenderID, IP: e.from.IP, Port: e.from.Port}, req)
+	if err != nil {
+		return nil
+	}
+
+	b, err := res.Encode()
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
@@ -0,0 +1,96 @@
+package gokad
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMemoryNetworkRPCs(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	remote, c := newMemoryNode(network)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := local.SendPing(ctx, c); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if got := local.FindNode(remote.ID); len(got) != 1 || !got[0].ID.Equal(remote.ID) {
+		t.Fatalf("Expected local to have learned about remote, but got %v\n", got)
+	}
+
+	contacts, err := local.SendFindNode(ctx, c, local.ID)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	// remote learned about local from the ping
+	if len(contacts) != 1 || !contacts[0].ID.Equal(local.ID) {
+		t.Fatalf("Expected remote to know only the local node, but got %v\n", contacts)
+	}
+
+	key := GenerateRandomID()
+	if err := local.SendStore(ctx, c, key, net.IPv4(10, 0, 0, 1), 4000); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	_, providers, err := local.SendFindValue(ctx, c, key)
+	if err != nil || len(providers) != 1 {
+		t.Fatalf("Expected value to be found, but got %d values err: %v\n", len(providers), err)
+	}
+
+	h, _ := ParseHostPort(providers[0].Value)
+	if h.Port != 4000 {
+		t.Errorf("Expected port to be %d, but got %d\n", 4000, h.Port)
+	}
+}
+
+func TestMemoryNetworkUnreachable(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	remote, c := newMemoryNode(network)
+	remote.transport.Close()
+
+	err := local.SendPing(context.Background(), c)
+	if !errors.Is(err, ErrHostUnreachable) {
+		t.Errorf("Expected error %s, but got %v\n", ErrHostUnreachable, err)
+	}
+}
+
+func TestSendWithoutTransport(t *testing.T) {
+	err := NewDHT().SendPing(context.Background(), generateRandomContact())
+	if !errors.Is(err, ErrNoTransport) {
+		t.Errorf("Expected error %s, but got %v\n", ErrNoTransport, err)
+	}
+}
+
+func newMemoryNode(network *MemoryNetwork) (*DHT, Contact) {
+	transport := network.NewTransport()
+	dht := DHTFrom(DHTConfig{Transport: transport})
+
+	return dht, Contact{ID: dht.ID, IP: transport.Addr().IP, Port: transport.Addr().Port}
+}
+
+func TestResponseFromOtherIDIsRejected(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	_, c := newMemoryNode(network)
+
+	// a node at c's address answering with an id other than c's
+	impostor := c
+	impostor.ID = GenerateRandomID()
+
+	err := local.SendPing(context.Background(), impostor)
+	if !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("Expected error %s, but got %v\n", ErrUnexpectedResponse, err)
+	}
+
+	if got := local.FindNode(c.ID); len(got) != 0 {
+		t.Errorf("Expected no contact to be added, but got %v\n", got)
+	}
+}
@@ -0,0 +1,343 @@
+package gokad
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// Wire format (version 1)
+//
+// Every message is a single datagram of at most MessageSize bytes. All integers are big endian.
+//
+//   offset   size  field
+//   0        1     version (ProtocolVersion)
+//   1        1     type (PING, STORE, FIND_NODE, FIND_VALUE)
+//   2        1     flags. bit 0 is set for responses, all other bits must be 0
+//   3        1     id length n (SIZE by default). Both ids have the same length
+//   4        n     rpc id. random, chosen by the requester and echoed in the response
+//   4+n      n     sender id
+//   4+2n     2     payload length m
+//   6+2n     m     payload
+//
+// Payloads
+//
+//   PING       request:  empty
+//              response: empty
+//   STORE      request:  key (n) <- publisher id (n) <- 4 bytes ttl in milliseconds <- value
+//              response: empty
+//   FIND_NODE  request:  target id (n)
+//              response: contact list
+//   FIND_VALUE request:  key (n)
+//              response: 1 byte status. 1 followed by a provider list, 0 followed by a contact list
+//
+//   value:         the rest of the payload, at most MaxValueSize bytes for ids of SIZE bytes
+//   provider list: repeated publisher id (n) <- 4 bytes remaining ttl in milliseconds
+//                  <- 2 bytes length l <- l bytes value
+//   contact list:  repeated 1 byte length l <- l bytes Contact.Serialize (see SerializeContacts).
+//                  l is n+6 for IPv4 and n+18 for IPv6 contacts
+//
+// A message whose length does not match its header exactly is rejected.
+
+// ProtocolVersion is the version of the wire format written and accepted by this package
+const ProtocolVersion = 1
+
+// headerSize is the size of a message with ids of SIZE bytes without its payload
+const headerSize = 6 + 2*SIZE
+
+// maxPayloadSize is the room left for the payload in a single message with ids of SIZE bytes
+const maxPayloadSize = MessageSize - headerSize
+
+// payloadSize returns the room left for the payload in a single message with ids of n bytes
+func payloadSize(n int) int {
+	return MessageSize - 6 - 2*n
+}
+
+// flagResponse marks a message as a response
+const flagResponse = 1
+
+// Errors
+var ErrMessageTooLarge = errors.New("Message Too Large")
+var ErrMalformedMessage = errors.New("Malformed Message")
+var ErrUnsupportedVersion = errors.New("Unsupported Protocol Version")
+
+// MessageType identifies the rpc a message belongs to
+type MessageType byte
+
+const (
+	PING MessageType = iota + 1
+	STORE
+	FIND_NODE
+	FIND_VALUE
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case PING:
+		return "PING"
+	case STORE:
+		return "STORE"
+	case FIND_NODE:
+		return "FIND_NODE"
+	case FIND_VALUE:
+		return "FIND_VALUE"
+	}
+
+	return "UNKNOWN"
+}
+
+func (t MessageType) valid() bool {
+	return t >= PING && t <= FIND_VALUE
+}
+
+// Message is a single rpc request or response exchanged between two nodes
+type Message struct {
+	Type     MessageType
+	Response bool
+	RPCID    ID
+	SenderID ID
+	Payload  []byte
+}
+
+// NewRequest returns a request of type t with a fresh random rpc id of the same length as sender
+func NewRequest(t MessageType, sender ID, payload []byte) Message {
+	return Message{
+		Type:     t,
+		RPCID:    GenerateRandomIDWithBits(sender.Bits()),
+		SenderID: sender,
+		Payload:  payload,
+	}
+}
+
+// NewResponse returns the response to req carrying payload
+func NewResponse(req Message, sender ID, payload []byte) Message {
+	return Message{
+		Type:     req.Type,
+		Response: true,
+		RPCID:    req.RPCID,
+		SenderID: sender,
+		Payload:  payload,
+	}
+}
+
+// Encode serializes the message into its wire format
+func (m Message) Encode() ([]byte, error) {
+	n := len(m.SenderID)
+	if !m.Type.valid() || n == 0 || n > math.MaxUint8 || len(m.RPCID) != n {
+		return nil, ErrMalformedMessage
+	}
+
+	if len(m.Payload) > payloadSize(n) {
+		return nil, ErrMessageTooLarge
+	}
+
+	var flags byte
+	if m.Response {
+		flags |= flagResponse
+	}
+
+	out := make([]byte, 0, MessageSize-payloadSize(n)+len(m.Payload))
+	out = append(out, ProtocolVersion, byte(m.Type), flags, byte(n))
+	out = append(out, m.RPCID...)
+	out = append(out, m.SenderID...)
+
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(m.Payload)))
+	out = append(out, length...)
+	out = append(out, m.Payload...)
+
+	return out, nil
+}
+
+// DecodeMessage parses a message from its wire format.
+// The returned message does not share memory with b
+func DecodeMessage(b []byte) (Message, error) {
+	if len(b) > MessageSize {
+		return Message{}, ErrMessageTooLarge
+	}
+
+	if len(b) < 4 {
+		return Message{}, ErrMalformedMessage
+	}
+
+	if b[0] != ProtocolVersion {
+		return Message{}, ErrUnsupportedVersion
+	}
+
+	t := MessageType(b[1])
+	flags := b[2]
+	n := int(b[3])
+	header := 6 + 2*n
+	if !t.valid() || flags&^flagResponse != 0 || n == 0 || len(b) < header {
+		return Message{}, ErrMalformedMessage
+	}
+
+	length := int(binary.BigEndian.Uint16(b[4+2*n : header]))
+	if len(b) != header+length {
+		return Message{}, ErrMalformedMessage
+	}
+
+	m := Message{
+		Type:     t,
+		Response: flags&flagResponse != 0,
+		RPCID:    make(ID, n),
+		SenderID: make(ID, n),
+		Payload:  make([]byte, length),
+	}
+
+	copy(m.RPCID, b[4:4+n])
+	copy(m.SenderID, b[4+n:4+2*n])
+	copy(m.Payload, b[header:])
+
+	return m, nil
+}
+
+// encodeContacts writes contacts in the format of SerializeContacts.
+// Contacts that would grow the output beyond max bytes are left out
+func encodeContacts(contacts []Contact, max int) []byte {
+	out := make([]byte, 0)
+	for _, c := range contacts {
+		next := appendSerializedContact(out, c)
+		if len(next) > max {
+			break
+		}
+		out = next
+	}
+
+	return out
+}
+
+// maxStoreTTL is the largest ttl a STORE request can carry
+const maxStoreTTL = time.Duration(math.MaxUint32) * time.Millisecond
+
+func encodeStoreRequest(key ID, publisher ID, v Value, ttl time.Duration) []byte {
+	n := len(key)
+	out := make([]byte, 2*n, 2*n+4+len(v))
+	copy(out, key)
+	copy(out[n:], publisher)
+	out = appendTTL(out, ttl)
+
+	return append(out, v...)
+}
+
+// appendTTL writes ttl in milliseconds, capped at maxStoreTTL
+func appendTTL(out []byte, ttl time.Duration) []byte {
+	if ttl > maxStoreTTL {
+		ttl = maxStoreTTL
+	}
+
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(ttl/time.Millisecond))
+
+	return append(out, b...)
+}
+
+func readTTL(b []byte) time.Duration {
+	return time.Duration(binary.BigEndian.Uint32(b)) * time.Millisecond
+}
+
+// decodeStoreRequest parses the payload of a STORE request with ids of n bytes
+func decodeStoreRequest(p []byte, n int) (ID, ID, Value, time.Duration, error) {
+	if len(p) < 2*n+4 {
+		return nil, nil, nil, 0, ErrMalformedMessage
+	}
+
+	v := make(Value, len(p)-2*n-4)
+	copy(v, p[2*n+4:])
+	ttl := readTTL(p[2*n : 2*n+4])
+
+	return ID(p[:n]), ID(p[n : 2*n]), v, ttl, nil
+}
+
+// encodeProviders writes a provider list.
+// Providers that would grow the output beyond max bytes are left out
+func encodeProviders(providers []Provider, max int) []byte {
+	out := make([]byte, 0)
+	for _, pr := range providers {
+		if len(out)+len(pr.Publisher)+6+len(pr.Value) > max {
+			break
+		}
+
+		out = append(out, pr.Publisher...)
+		out = appendTTL(out, pr.TTL)
+		out = appendUint16(out, uint16(len(pr.Value)))
+		out = append(out, pr.Value...)
+	}
+
+	return out
+}
+
+// decodeProviders parses a provider list with publisher ids of n bytes
+func decodeProviders(b []byte, n int) ([]Provider, error) {
+	out := make([]Provider, 0)
+	for len(b) > 0 {
+		if len(b) < n+6 {
+			return nil, ErrMalformedMessage
+		}
+
+		l := int(binary.BigEndian.Uint16(b[n+4 : n+6]))
+		if len(b) < n+6+l {
+			return nil, ErrMalformedMessage
+		}
+
+		pr := Provider{Publisher: make(ID, n), Value: make(Value, l), TTL: readTTL(b[n : n+4])}
+		copy(pr.Publisher, b[:n])
+		copy(pr.Value, b[n+6:n+6+l])
+
+		out = append(out, pr)
+		b = b[n+6+l:]
+	}
+
+	return out, nil
+}
+
+// decodeIDPayload parses the payload of a FIND_NODE or FIND_VALUE request with ids of n bytes
+func decodeIDPayload(p []byte, n int) (ID, error) {
+	if len(p) != n {
+		return nil, ErrMalformedMessage
+	}
+
+	return ID(p), nil
+}
+
+// encodeFindValueResponse writes the providers if there are any and the contacts otherwise.
+// The response leaves room for ids of n bytes in the message header
+func encodeFindValueResponse(contacts []Contact, providers []Provider, n int) []byte {
+	if len(providers) > 0 {
+		return append([]byte{1}, encodeProviders(providers, payloadSize(n)-1)...)
+	}
+
+	return append([]byte{0}, encodeContacts(contacts, payloadSize(n)-1)...)
+}
+
+// decodeFindValueResponse parses the payload of a FIND_VALUE response with ids of n bytes
+func decodeFindValueResponse(p []byte, n int) ([]Contact, []Provider, error) {
+	if len(p) < 1 {
+		return nil, nil, ErrMalformedMessage
+	}
+
+	switch p[0] {
+	case 1:
+		providers, err := decodeProviders(p[1:], n)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(providers) == 0 {
+			return nil, nil, ErrMalformedMessage
+		}
+		return nil, providers, nil
+	case 0:
+		contacts, err := deserializeContacts(p[1:], n)
+		if err != nil {
+			return nil, nil, err
+		}
+		return contacts, nil, nil
+	}
+
+	return nil, nil, ErrMalformedMessage
+}
@@ -0,0 +1,159 @@
+package gokad
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeMessage(t *testing.T) {
+	sender := GenerateRandomID()
+	target := GenerateRandomID()
+	req := NewRequest(FIND_NODE, sender, target)
+
+	b, err := req.Encode()
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(b) != headerSize+SIZE {
+		t.Errorf("Expected encoded length to be %d, but got %d\n", headerSize+SIZE, len(b))
+	}
+
+	out, err := DecodeMessage(b)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if out.Type != FIND_NODE || out.Response {
+		t.Errorf("Expected a FIND_NODE request, but got %s (response: %t)\n", out.Type, out.Response)
+	}
+
+	if !out.RPCID.Equal(req.RPCID) || !out.SenderID.Equal(sender) {
+		t.Errorf("Expected rpc id %s and sender %s, but got %s and %s\n", req.RPCID, sender, out.RPCID, out.SenderID)
+	}
+
+	if !bytes.Equal(out.Payload, target) {
+		t.Errorf("Expected payload %x, but got %x\n", []byte(target), out.Payload)
+	}
+
+	res := NewResponse(out, GenerateRandomID(), nil)
+	b, _ = res.Encode()
+	out, err = DecodeMessage(b)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if !out.Response || !out.RPCID.Equal(req.RPCID) {
+		t.Errorf("Expected a response to rpc %s, but got %s (response: %t)\n", req.RPCID, out.RPCID, out.Response)
+	}
+}
+
+func TestDecodeMalformedMessage(t *testing.T) {
+	valid, _ := NewRequest(PING, GenerateRandomID(), []byte{1, 2, 3}).Encode()
+
+	badVersion := append([]byte{}, valid...)
+	badVersion[0] = ProtocolVersion + 1
+
+	badType := append([]byte{}, valid...)
+	badType[1] = 0
+
+	badFlags := append([]byte{}, valid...)
+	badFlags[2] = 2
+
+	cases := []struct {
+		Name string
+		IN   []byte
+		OUT  error
+	}{
+		{"empty", []byte{}, ErrMalformedMessage},
+		{"truncated header", valid[:headerSize-1], ErrMalformedMessage},
+		{"truncated payload", valid[:len(valid)-1], ErrMalformedMessage},
+		{"trailing bytes", append(append([]byte{}, valid...), 0), ErrMalformedMessage},
+		{"version", badVersion, ErrUnsupportedVersion},
+		{"type", badType, ErrMalformedMessage},
+		{"flags", badFlags, ErrMalformedMessage},
+		{"oversized", make([]byte, MessageSize+1), ErrMessageTooLarge},
+	}
+
+	for _, c := range cases {
+		_, err := DecodeMessage(c.IN)
+		if !errors.Is(err, c.OUT) {
+			t.Errorf("Case %s: Expected error %s, but got %v\n", c.Name, c.OUT, err)
+		}
+	}
+}
+
+func TestEncodeMessageTooLarge(t *testing.T) {
+	m := NewRequest(STORE, GenerateRandomID(), make([]byte, maxPayloadSize+1))
+
+	_, err := m.Encode()
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected error %s, but got %v\n", ErrMessageTooLarge, err)
+	}
+}
+
+func TestEncodeContactsRespectsLimit(t *testing.T) {
+	contacts := []Contact{generateRandomContact(), generateRandomContact(), generateRandomContact()}
+	size := len(contacts[0].Serialize()) + 1
+
+	out, err := DeserializeContacts(encodeContacts(contacts, 2*size+1))
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(out) != 2 {
+		t.Errorf("Expected %d contacts, but got %d\n", 2, len(out))
+	}
+}
+
+func TestEncodeProvidersRespectsLimit(t *testing.T) {
+	providers := []Provider{
+		{Publisher: GenerateRandomID(), Value: Value("first"), TTL: time.Hour},
+		{Publisher: GenerateRandomID(), Value: Value("second")},
+		{Publisher: GenerateRandomID(), Value: Value("third")},
+	}
+
+	out, err := decodeProviders(encodeProviders(providers, 2*(SIZE+6)+len("first")+len("second")), SIZE)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(out) != 2 {
+		t.Fatalf("Expected %d providers, but got %d\n", 2, len(out))
+	}
+
+	for i, pr := range out {
+		if !pr.Publisher.Equal(providers[i].Publisher) || !bytes.Equal(pr.Value, providers[i].Value) || pr.TTL != providers[i].TTL {
+			t.Errorf("Expected at index (%d) %q, but got %q\n", i, providers[i].Value, pr.Value)
+		}
+	}
+}
+
+func TestMessageWith256BitIDs(t *testing.T) {
+	sender := GenerateRandomIDWithBits(256)
+	m := NewRequest(FIND_NODE, sender, GenerateRandomIDWithBits(256))
+
+	b, err := m.Encode()
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	out, err := DecodeMessage(b)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if !out.SenderID.Equal(sender) || len(out.RPCID) != len(sender) {
+		t.Errorf("Expected sender %s, but got %s\n", sender, out.SenderID)
+	}
+
+	if _, err := decodeIDPayload(out.Payload, len(sender)); err != nil {
+		t.Errorf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if _, err := NewRequest(FIND_NODE, sender, make([]byte, payloadSize(len(sender))+1)).Encode(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected error %s, but got %v\n", ErrMessageTooLarge, err)
+	}
+}
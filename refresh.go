@@ -0,0 +1,66 @@
+package gokad
+
+import (
+	"context"
+	"time"
+)
+
+// Refresh performs a lookup for a random id in the range of every bucket
+// that has not seen a lookup within the refresh interval
+// @Source: Kademlia: A Peer-to-peer Information System Based on the XOR Metric
+// https://pdos.csail.mit.edu/~petar/papers/maymounkov-kademlia-lncs.pdf
+func (dht *DHT) Refresh(ctx context.Context) error {
+	stale := dht.routingTable.staleBuckets(time.Now().Add(-dht.refreshInterval))
+
+	return dht.refreshBuckets(ctx, stale)
+}
+
+// refreshBuckets performs a lookup for a random id in the range of each of the buckets
+func (dht *DHT) refreshBuckets(ctx context.Context, buckets []*KBucket) error {
+	for _, b := range buckets {
+		if _, err := dht.Lookup(ctx, b.randomID()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// refreshLoop refreshes stale buckets every refresh interval until the DHT is closed
+func (dht *DHT) refreshLoop() {
+	dht.runEvery(dht.refreshInterval, dht.Refresh)
+}
+
+// runEvery calls fn every interval until the DHT is closed.
+// The context passed to fn is cancelled once the DHT is closed
+func (dht *DHT) runEvery(interval time.Duration, fn func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx, cancel := dht.closeContext()
+	defer cancel()
+
+	for {
+		select {
+		case <-ticker.C:
+			fn(ctx)
+		case <-dht.done:
+			return
+		}
+	}
+}
+
+// closeContext returns a context that is cancelled once the DHT is closed
+func (dht *DHT) closeContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		select {
+		case <-dht.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,83 @@
+package gokad
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestRefreshLooksUpStaleBuckets(t *testing.T) {
+	network := NewMemoryNetwork()
+	routing, _ := newRandomRoutingTable(rand.New(rand.NewSource(5)), 200)
+	local := DHTFrom(DHTConfig{RoutingTable: routing, Transport: network.NewTransport(), RPCTimeout: 10 * time.Millisecond})
+	defer local.Close()
+
+	stale := time.Now().Add(-2 * DefaultRefreshInterval)
+	for _, b := range local.routingTable.buckets {
+		b.touch(stale)
+	}
+	touched := time.Now()
+	local.routingTable.buckets[0].touch(touched)
+
+	start := time.Now()
+	if err := local.Refresh(context.Background()); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	for i, b := range local.routingTable.buckets {
+		if i == 0 {
+			if !b.LastLookup().Equal(touched) {
+				t.Errorf("Expected fresh bucket not to be refreshed\n")
+			}
+			continue
+		}
+
+		if b.LastLookup().Before(start) {
+			t.Errorf("Expected bucket %d to be refreshed\n", i)
+		}
+	}
+}
+
+func TestBackgroundRefresh(t *testing.T) {
+	network := NewMemoryNetwork()
+	local := DHTFrom(DHTConfig{Transport: network.NewTransport(), RefreshInterval: 10 * time.Millisecond})
+	defer local.Close()
+
+	bucket, _ := local.routingTable.Bucket(0)
+	before := bucket.LastLookup()
+
+	waitFor(t, func() bool {
+		return bucket.LastLookup().After(before)
+	})
+}
+
+func TestContactTimestamps(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	_, c := newMemoryNode(network)
+
+	start := time.Now()
+	if err := local.SendPing(context.Background(), c); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	got := local.routingTable.bucketOf(c.ID).Head()
+
+	if got.LastSeen.Before(start) {
+		t.Errorf("Expected LastSeen to be set, but got %s\n", got.LastSeen)
+	}
+
+	// the first ping went out before the contact was known
+	if !got.LastContacted.IsZero() {
+		t.Errorf("Expected LastContacted to be zero, but got %s\n", got.LastContacted)
+	}
+
+	local.SendPing(context.Background(), c)
+
+	got = local.routingTable.bucketOf(c.ID).Head()
+
+	if got.LastContacted.Before(start) {
+		t.Errorf("Expected LastContacted to be set, but got %s\n", got.LastContacted)
+	}
+}
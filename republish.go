@@ -0,0 +1,78 @@
+package gokad
+
+import (
+	"context"
+	"time"
+)
+
+// Republish publishes every value published through Put again, so it outlives
+// the value ttl at the nodes storing it
+// @Source: Kademlia: A Peer-to-peer Information System Based on the XOR Metric
+// https://pdos.csail.mit.edu/~petar/papers/maymounkov-kademlia-lncs.pdf
+func (dht *DHT) Republish(ctx context.Context) error {
+	dht.mu.Lock()
+	published := make([]Record, 0, len(dht.published))
+	for _, r := range dht.published {
+		published = append(published, r)
+	}
+	dht.mu.Unlock()
+
+	for _, r := range published {
+		if _, err := dht.replicate(ctx, r.Key, r.Provider(), dht.valueTTL); err != nil && ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replicate stores every value held by this node at the k closest contacts to its key,
+// so the value survives the k closest nodes changing. A value that was stored or replicated
+// within the replicate interval is skipped, as the node that sent it is assumed to have
+// sent it to the other k closest nodes as well. Values keep their expiry time.
+// Only the replicated time of a record is updated, so a value stored again in the
+// meantime is not overwritten
+// @Source: Kademlia: A Peer-to-peer Information System Based on the XOR Metric
+// https://pdos.csail.mit.edu/~petar/papers/maymounkov-kademlia-lncs.pdf
+func (dht *DHT) Replicate(ctx context.Context) error {
+	now := time.Now()
+	before := now.Add(-dht.replicateInterval)
+
+	due := make([]Record, 0)
+	err := dht.store.Iterate(func(r Record) bool {
+		if r.Replicated.Before(before) {
+			due = append(due, r)
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range due {
+		if err := dht.store.MarkReplicated(r.Key, r.Publisher, now); err != nil {
+			return err
+		}
+
+		ttl := time.Until(r.Expires)
+		if ttl <= 0 {
+			continue
+		}
+
+		if _, err := dht.replicate(ctx, r.Key, r.Provider(), ttl); err != nil && ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// republishLoop republishes the published values every republish interval until the DHT is closed
+func (dht *DHT) republishLoop() {
+	dht.runEvery(dht.republishInterval, dht.Republish)
+}
+
+// replicateLoop replicates the stored values every replicate interval until the DHT is closed
+func (dht *DHT) replicateLoop() {
+	dht.runEvery(dht.replicateInterval, dht.Replicate)
+}
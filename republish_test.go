@@ -0,0 +1,87 @@
+package gokad
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplicateSkipsRecentlyStoredValues(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	remote, c := newMemoryNode(network)
+	local.RoutingTable().Add(c)
+
+	key := GenerateRandomID()
+	local.Store(key, net.IPv4(10, 0, 0, 1), 4000)
+
+	if err := local.Replicate(context.Background()); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if contacts, _ := remote.FindValue(key); contacts == nil {
+		t.Fatalf("Expected a value stored within the interval not to be replicated\n")
+	}
+
+	records, _ := local.store.Get(key)
+	r := records[0]
+	r.Replicated = time.Now().Add(-2 * DefaultReplicateInterval)
+	local.store.Put(r)
+
+	if err := local.Replicate(context.Background()); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if contacts, _ := remote.FindValue(key); contacts != nil {
+		t.Fatalf("Expected value to be replicated to %s\n", remote.ID)
+	}
+
+	// replicas keep the expiry time of the original, give or take the time the STORE took
+	replicas, _ := remote.store.Get(key)
+	if replica := replicas[0]; replica.Expires.After(r.Expires.Add(time.Second)) {
+		t.Errorf("Expected replica to expire by %s, but got %s\n", r.Expires, replica.Expires)
+	}
+}
+
+func TestRepublishStoresPublishedValuesAgain(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	remote, c := newMemoryNode(network)
+	local.RoutingTable().Add(c)
+
+	key := GenerateRandomID()
+	if _, err := local.Put(context.Background(), key, HostPort{Host: net.IPv4(10, 0, 0, 1), Port: 4000}.Value()); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	remote.store.Expire(time.Now().Add(2 * DefaultValueTTL))
+	if contacts, _ := remote.FindValue(key); contacts == nil {
+		t.Fatalf("Expected value to be expired at %s\n", remote.ID)
+	}
+
+	if err := local.Republish(context.Background()); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if contacts, _ := remote.FindValue(key); contacts != nil {
+		t.Errorf("Expected value to be republished to %s\n", remote.ID)
+	}
+}
+
+func TestBackgroundRepublish(t *testing.T) {
+	network := NewMemoryNetwork()
+	local := DHTFrom(DHTConfig{Transport: network.NewTransport(), RepublishInterval: 10 * time.Millisecond})
+	defer local.Close()
+	remote, c := newMemoryNode(network)
+	local.RoutingTable().Add(c)
+
+	key := GenerateRandomID()
+	local.Put(context.Background(), key, HostPort{Host: net.IPv4(10, 0, 0, 1), Port: 4000}.Value())
+	remote.store.Expire(time.Now().Add(2 * DefaultValueTTL))
+
+	waitFor(t, func() bool {
+		contacts, _ := remote.FindValue(key)
+		return contacts == nil
+	})
+}
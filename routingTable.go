@@ -1,32 +1,56 @@
 package gokad
 
-import "math"
-
-// routingTable that hold the KBuckets
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// MaxK is the largest number of contacts a bucket can hold, bounded by the one byte counts of snapshots
+const MaxK = math.MaxUint8
+
+// routingTable that hold the KBuckets.
+// The buckets are the leaves of a binary tree over the id space. The table starts with a
+// single bucket covering every id, which is split in two whenever it is full and either
+// contains our own id or the new contact is one of the k closest contacts we know of.
+// The buckets are ordered by their distance to our own id, closest first, and the Index
+// of every bucket is its position in that order.
+// All exported methods are safe for concurrent use
+// @Source: Kademlia: A Peer-to-peer Information System Based on the XOR Metric
+// https://pdos.csail.mit.edu/~petar/papers/maymounkov-kademlia-lncs.pdf
 type RoutingTable struct {
 	id      ID
+	k       int
+	mu      sync.RWMutex
 	buckets []*KBucket
 }
 
 // NewRoutingTable returns a newly ininitalized routing table
-// The routing table's size is determined by MaxRoutingTableSize which is set to 160
+// with a single bucket that covers the whole id space
 func NewRoutingTable(id ID) *RoutingTable {
-	r := &RoutingTable{
-		id:      id,
-		buckets: make([]*KBucket, MaxRoutingTableSize),
-	}
+	return NewRoutingTableWithK(id, K)
+}
 
-	for i := range r.buckets {
-		r.buckets[i] = NewKBucket(i)
+// NewRoutingTableWithK returns a newly ininitalized routing table whose buckets hold k contacts.
+// The id space is that of ids with the same length as id. It panics unless k is within 1 - MaxK
+func NewRoutingTableWithK(id ID, k int) *RoutingTable {
+	if k < 1 || k > MaxK {
+		panic(fmt.Sprintf("gokad: k %d out of range 1 - %d", k, MaxK))
 	}
 
-	return r
+	return &RoutingTable{
+		id:      id,
+		k:       k,
+		buckets: []*KBucket{newKBucket(0, k, len(id))},
+	}
 
 }
 
 /*  Add adds a new contact into the appropriate k-bucket within the routing table
     returning the contact that was added OR the head of the bucket, the insertion index and an error if there was one.
-    The head of the bucket is only returned if there is also a Bucket ErrBucketAtCapacity error.
+    The head of the bucket is only returned if there is also a *BucketFullError, which matches ErrBucketAtCapacity.
     We do this so we can ping the head to see if it is still active
 
         "If Bucket contains MaxCapacity, the node at the head is pinged. If it replies, the current head is moved
@@ -34,29 +58,74 @@ func NewRoutingTable(id ID) *RoutingTable {
         added to the tail"
 	@source: Implementation of the Kademlia Distributed Hash Table by Bruno Spori Semester Thesis
    https://pub.tik.ee.ethz.ch/students/2006-So/SA-2006-19.pdf
+
+    A DHT with a transport does the pinging itself for every contact it hears from.
+
+    A full bucket is split instead if it contains our own id, or if fewer than k known contacts
+    are closer to us than c, so that we keep every contact of the subtree closest to us even
+    if the tree is unbalanced. The contact is then inserted into the matching half.
+
+    Contacts whose id does not have the length of our own id are rejected with ErrInvalidIDLength.
 **/
 func (r *RoutingTable) Add(c Contact) (Contact, int, error) {
-	delta := r.id.DistanceTo(c.ID)
-	index := r.determineBucketIndex(delta)
+	if len(c.ID) != len(r.id) {
+		return c, -1, ErrInvalidIDLength
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		index := r.indexOf(c.ID)
+		bucket := r.buckets[index]
+
+		contactOrHead, err := bucket.Insert(c)
+		if !errors.Is(err, ErrBucketAtCapacity) || !r.canSplit(bucket, c.ID) {
+			return contactOrHead, index, err
+		}
 
-	contactOrHead, err := r.insertAt(index, c)
+		r.split(index)
+	}
+}
+
+// ID returns the id of the node the routing table belongs to
+func (r *RoutingTable) ID() ID {
+	return r.id
+}
 
-	return contactOrHead, index, err
+// K returns the number of contacts every bucket holds
+func (r *RoutingTable) K() int {
+	return r.k
 }
 
-func (r *RoutingTable) Bucket(index int) (KBucket, bool) {
+// Bucket returns the bucket at index. Indices out of range are clamped to the first or last bucket
+func (r *RoutingTable) Bucket(index int) (*KBucket, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	if len(r.buckets) == 0 {
-		return KBucket{}, false
+		return nil, false
 	}
-	if index > len(r.buckets) {
-		return *r.buckets[len(r.buckets) - 1], true
+	if index >= len(r.buckets) {
+		return r.buckets[len(r.buckets)-1], true
 	}
 
 	if index < 0 {
-		return *r.buckets[0], true
+		return r.buckets[0], true
 	}
 
-	return *r.buckets[index], true
+	return r.buckets[index], true
+}
+
+// Buckets returns the current buckets of the routing table ordered by their distance to our own id, closest first
+func (r *RoutingTable) Buckets() []*KBucket {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]*KBucket, len(r.buckets))
+	copy(out, r.buckets)
+
+	return out
 }
 
 // GetAlphaNodes gets α nodes out of its k-bucket where the id to be looked up would fit in.
@@ -65,115 +134,198 @@ func (r *RoutingTable) Bucket(index int) (KBucket, bool) {
 // Source: Implementation of the Kademlia Hash Table by Bruno Spori
 // https://pub.tik.ee.ethz.ch/students/2006-So/SA-2006-19.pdf
 func (r *RoutingTable) GetAlphaNodes(alpha int, id ID) []Contact {
-	return r.getXClosestContacts(alpha, id)
+	return r.ClosestContacts(id, alpha)
 }
 
-func (r *RoutingTable) insertAt(i int, c Contact) (Contact, error) {
-	bucket := r.buckets[i]
-	return bucket.Insert(c)
-}
+// ClosestContacts returns the n contacts with the smallest XOR distance to target across
+// all buckets, closest first. The buckets cover disjoint ranges of the id space, so once
+// they are ordered by the smallest distance to target of any id they cover, every bucket
+// only holds contacts further away from target than the buckets visited before it.
+// Contacts are compared by their full distance, so the visit stops as soon as n contacts were found
+func (r *RoutingTable) ClosestContacts(target ID, n int) []Contact {
+	out := make([]Contact, 0, n)
+	if n <= 0 {
+		return out
+	}
 
-// determineBucketIndex determines at which index the id/contact should be inserted based on the distance
-func (r *RoutingTable) determineBucketIndex(delta NodeID) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	for i := 0; i < MaxRoutingTableSize; i++ {
-		bit := delta.GetBitAt(uint(i))
-		if bit > 0 {
-			return MaxRoutingTableSize - 1 - i
+	for _, b := range r.orderOfVisits(target) {
+		b.Walk(func(c Contact) bool {
+			out = insertClosest(out, c, target, n)
+			return false
+		})
+
+		if len(out) >= n {
+			break
 		}
 	}
 
-	return 0
+	return out
+}
+
+// Remove removes the contact with the given id from the routing table.
+// The most recently seen contact of the bucket's replacement cache takes its place.
+// It returns false if the routing table does not contain such a contact
+func (r *RoutingTable) Remove(id ID) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
+	return r.bucketOf(id).Remove(id)
 }
 
-// determineOrderOfVisits determines in which order we need to visit
-// our k-buckets to find nodes close to delta
-// we do so by looping through the bit string of delta.
-// consider a delta of 1 0 1 0 (in an address space of 4 for brevity)
-// The order in which we visit the k-buckets will be:
-//   bucket index 3 -> bucket index 1 -> bucket index 0 -> bucket index 2
-// It may be better explained by looking the the full address space as a binary tree
-/**
-                                 /         \
-                                /           \
-                               /             \
-                              /               \
-                             /                 \
-                            /                   \
-                           /                     \
-                          / \                   / \
-                         /   \                 /   \
-                        /     \               /     \
-                       /       \             /       \
-                      /         \           /         \
-                     /           \         /           \
-                    / \         / \       /\           /\
-                   /   \       /   \     /  \         /  \
-                  /\   /\     /\  / \   / \ /\       /\ / \
-                 0 1  2 3    4 5 6  7  8  91011     12131415
-
-    Consider Our ID: 0010 (2)
-             Target: 1000 (8)
-                    ---------
-             Delta:  1010
-
-   The first bucket we visit is bucket 3. This bucket contains ids ranging 8 - 15.
-   In this bucket the deltas range from 0000 - 0111
-   The next bucket we visit is bucket 1. This bucket contains ids ranging from 0 - 1
-   In this bucket the deltas range from 1000 - 1001
-   The next bucket we visit is bucket 0. This bucket contains ids ranging from 2 - 3
-   In this bucket the deltas range from 1010 - 1011
-   The next and last bucket we visit is bucket 2. This bucket contains ids ranging from 4 - 7
-   In this bucket the deltas range from 1100 - 1111
-
-As you can see with every bucket we visit the delta to our Target increases.
-
- */
-func (r *RoutingTable) determineOrderOfVisits(delta NodeID) []int {
-	phase1 := make([]int, 0)
-	phase2 := make([]int, 0)
-
-	// loop through the entire address space.
-	// ie 0 -> 159
-	for i := 0; i < MaxRoutingTableSize; i++ {
-		bit := delta.GetBitAt(uint(i))
-		realBucketIndex := bucketIndex(i)
-		if bit > 0 {
-			phase1 = append(phase1, realBucketIndex)
-		} else {
-			phase2 = append([]int{realBucketIndex}, phase2...)
+// Fail records a failed RPC to the contact with the given id. Contacts that failed
+// MaxFailures consecutive RPCs are removed and true is returned.
+// Adding the contact again resets its failures
+func (r *RoutingTable) Fail(id ID) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-		}
-	}
+	return r.bucketOf(id).Fail(id) >= MaxFailures
+}
+
+// touch records that a lookup for id was performed
+func (r *RoutingTable) touch(id ID) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	phase1 = append(phase1, phase2...)
-	return phase1
+	r.bucketOf(id).touch(time.Now())
 }
 
-func (r *RoutingTable) getXClosestContacts(x int, id ID) []Contact {
-	delta := r.id.DistanceTo(id)
-	visits := r.determineOrderOfVisits(delta)
-	out := make([]Contact, 0)
+// contacted records that an RPC was sent to the contact with the given id
+func (r *RoutingTable) contacted(id ID) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	for _, bucketIndex := range visits {
-		bucket := r.buckets[bucketIndex]
-		res := bucket.getXClosestContacts(x, id)
+	r.bucketOf(id).contacted(id, time.Now())
+}
 
-		if len(res) > 0 {
-			for _, c := range res {
-				out = append(out, c)
+// countCloser returns the number of contacts that are closer to target than id
+func (r *RoutingTable) countCloser(target ID, id ID) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
+	return r.countCloserLocked(target, id)
+}
+
+// countCloserLocked is countCloser for callers that hold the lock of the routing table already
+func (r *RoutingTable) countCloserLocked(target ID, id ID) int {
+	count := 0
+	for _, b := range r.buckets {
+		b.Walk(func(c Contact) bool {
+			if target.CompareDistanceTo(c.ID, id) > 0 {
+				count++
 			}
+			return false
+		})
+	}
+
+	return count
+}
+
+// staleBuckets returns the buckets that have not seen a lookup since before
+func (r *RoutingTable) staleBuckets(before time.Time) []*KBucket {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]*KBucket, 0)
+	for _, b := range r.buckets {
+		if b.LastLookup().Before(before) {
+			out = append(out, b)
 		}
+	}
 
-		if len(out) >= x {
-			break
+	return out
+}
+
+// bucketsFartherThan returns the buckets that are further away from our own id than the bucket id falls into
+func (r *RoutingTable) bucketsFartherThan(id ID) []*KBucket {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	farther := r.buckets[r.indexOf(id)+1:]
+	out := make([]*KBucket, len(farther))
+	copy(out, farther)
+
+	return out
+}
+
+// bucketOf returns the bucket the contact with the given id belongs to.
+// The caller must hold the lock of the routing table
+func (r *RoutingTable) bucketOf(id ID) *KBucket {
+	return r.buckets[r.indexOf(id)]
+}
+
+// indexOf returns the index of the bucket whose range id falls into
+func (r *RoutingTable) indexOf(id ID) int {
+	for i, b := range r.buckets {
+		if b.covers(id) {
+			return i
 		}
 	}
 
-	bounds := int(math.Min(float64(x), float64(len(out))))
+	return 0
+}
 
-	return out[:bounds]
+// canSplit returns true if the full bucket b may be split to make room for the contact with the given id
+func (r *RoutingTable) canSplit(b *KBucket, id ID) bool {
+	if b.depth >= r.id.Bits() {
+		return false
+	}
 
+	return b.covers(r.id) || r.countCloserLocked(r.id, id) < r.k
+}
+
+// split replaces the bucket at index with its two halves. The half that is closer
+// to our own id takes its place and the other one follows it
+func (r *RoutingTable) split(index int) {
+	b := r.buckets[index]
+	zero, one := b.split()
+	if r.id.GetBitAt(uint(b.depth)) == 1 {
+		zero, one = one, zero
+	}
+
+	buckets := make([]*KBucket, 0, len(r.buckets)+1)
+	buckets = append(buckets, r.buckets[:index]...)
+	buckets = append(buckets, zero, one)
+	buckets = append(buckets, r.buckets[index+1:]...)
+
+	for i, b := range buckets {
+		b.setIndex(i)
+	}
+	r.buckets = buckets
+}
+
+// orderOfVisits returns the buckets ordered by the smallest distance to target of any id they cover.
+// Consider our id 0010 and a target 1000 (in an address space of 4 for brevity) with the buckets
+// 1 (8 - 15), 01 (4 - 7), 000 (0 - 1) and 001 (2 - 3).
+// The deltas to target range from 0000 - 0111 in bucket 1, 1100 - 1111 in bucket 01,
+// 1000 - 1001 in bucket 000 and 1010 - 1011 in bucket 001, so we visit 1 -> 000 -> 001 -> 01.
+// The caller must hold the lock of the routing table
+func (r *RoutingTable) orderOfVisits(target ID) []*KBucket {
+	out := make([]*KBucket, 0, len(r.buckets))
+	distances := make([]Distance, 0, len(r.buckets))
+
+	for _, b := range r.buckets {
+		d := b.distanceTo(target)
+
+		i := len(out)
+		for i > 0 && compareDistance(d, distances[i-1]) < 0 {
+			i--
+		}
+
+		out = append(out, nil)
+		distances = append(distances, nil)
+		copy(out[i+1:], out[i:])
+		copy(distances[i+1:], distances[i:])
+		out[i] = b
+		distances[i] = d
+	}
+
+	return out
+}
+
+func (r *RoutingTable) getXClosestContacts(x int, id ID) []Contact {
+	return r.ClosestContacts(id, x)
 }
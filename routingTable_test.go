@@ -1,75 +1,111 @@
 package gokad
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
 	"reflect"
 	"strings"
 	"testing"
 )
 
-func TestInsertDistanceBetween2Ids(t *testing.T) {
+func TestNewRoutingTableHasSingleBucket(t *testing.T) {
+	routing := NewRoutingTable(GenerateRandomID())
 
-	cases := []struct {
-		id1 string
-		id2 string
-		out int
-	}{
-		{
-			id1: "480F741BC1B397C54A54858E4E2A8840B2BC766B", // 0100100000001111011101000001101111000001101100111001011111000101010010100101010010000101100011100100111000101010100010000100000010110010101111000111011001101011
-			id2: "C80F741BC1B397C54A54858E4E2A8840B2BC766B", // 1100100000001111011101000001101111000001101100111001011111000101010010100101010010000101100011100100111000101010100010000100000010110010101111000111011001101011
-			out: 159,
-		},
-		{
-			id1: "480F741BC1B397C54A54858E4E2A8840B2BC766B",
-			id2: "480F741BC1B397C54A54858E4E2A8840B2BC766B",
-			out: 0,
-		},
-		{
-			id1: "489887A2C81C7920911815BCD99D3F19AB3D633D",
-			id2: "DC84DABE02FB31B9011800635C03794213EBA1F0",
-			out: 159,
-		},
+	buckets := routing.Buckets()
+	if len(buckets) != 1 {
+		t.Fatalf("Expected %d bucket, but got %d\n", 1, len(buckets))
 	}
 
-	for _, c := range cases {
-		id1, _ := From(c.id1)
-		id2, _ := From(c.id2)
-		routing := NewRoutingTable(id1)
-		delta := id1.DistanceTo(id2)
-		index := routing.determineBucketIndex(delta)
-		if index != c.out {
-			t.Logf("Id1: %s\nId2: %s\n", c.id1, c.id2)
-			t.Logf("Delta: %x\n", delta)
-			t.Errorf("Expected index to be %d, but got %d", c.out, index)
+	for i := 0; i < 10; i++ {
+		if !buckets[0].covers(GenerateRandomID()) {
+			t.Errorf("Expected the bucket to cover the whole id space\n")
 		}
 	}
+}
+
+func TestSplitBucketContainingOwnID(t *testing.T) {
+	routing := NewRoutingTable(GenerateID([]byte{0}))
+	for i := 0; i < MaxCapacity; i++ {
+		routing.Add(generateContactFrom("80" + GenerateRandomID().String()[2:]))
+	}
+
+	near := generateContactFrom("01" + GenerateRandomID().String()[2:])
+	_, index, err := routing.Add(near)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(routing.buckets) != 2 {
+		t.Fatalf("Expected %d buckets, but got %d\n", 2, len(routing.buckets))
+	}
 
+	if index != 0 || routing.buckets[0].Size() != 1 {
+		t.Errorf("Expected %s to be the only contact of bucket 0, but got index %d\n", near.ID, index)
+	}
+
+	if far := routing.buckets[1]; far.Size() != MaxCapacity || far.Depth() != 1 || far.Index() != 1 {
+		t.Errorf("Expected bucket 1 to hold %d contacts at depth 1, but got %d at depth %d\n", MaxCapacity, far.Size(), far.Depth())
+	}
 }
 
-func TestDetermineInsertIndex(t *testing.T) {
-	cases := []struct {
-		IN  string
-		OUT int
-	}{
-		{
-			IN:  "24d48bcb03a8b24aaf531c99bef39bee2d666496",
-			OUT: 157,
-		},
-		{
-			IN:  "858b07598756998c87f48cdafdd9fad9f1a714ff",
-			OUT: 159,
-		},
+func TestFullBucketFarAwayIsNotSplit(t *testing.T) {
+	routing := NewRoutingTable(GenerateID([]byte{0}))
+	for i := 0; i < MaxCapacity; i++ {
+		routing.Add(generateContactFrom("01" + GenerateRandomID().String()[2:]))
+		routing.Add(generateContactFrom("80" + GenerateRandomID().String()[2:]))
 	}
 
-	for _, c := range cases {
-		id, _ := From(c.IN)
-		routing := NewRoutingTable(nil)
-		index := routing.determineBucketIndex(id)
-		if index != c.OUT {
-			t.Errorf("Expected %d but got %d\n", c.OUT, index)
-		}
+	c := generateContactFrom("80" + GenerateRandomID().String()[2:])
+	_, _, err := routing.Add(c)
+	if !errors.Is(err, ErrBucketAtCapacity) {
+		t.Errorf("Expected error %s, but got %v\n", ErrBucketAtCapacity, err)
+	}
+
+	if len(routing.buckets) != 2 {
+		t.Errorf("Expected %d buckets, but got %d\n", 2, len(routing.buckets))
+	}
+
+	if r := routing.bucketOf(c.ID).Replacements(); len(r) != 1 || !r[0].ID.Equal(c.ID) {
+		t.Errorf("Expected %s to be put into the replacement cache\n", c.ID)
+	}
+
+	var full *BucketFullError
+	if !errors.As(err, &full) {
+		t.Fatalf("Expected a *BucketFullError, but got %T\n", err)
+	}
+
+	bucket := routing.bucketOf(c.ID)
+	if full.Index != bucket.Index() {
+		t.Errorf("Expected bucket index %d, but got %d\n", bucket.Index(), full.Index)
+	}
+
+	if !full.Head.ID.Equal(bucket.Head().ID) {
+		t.Errorf("Expected head %s, but got %s\n", bucket.Head().ID, full.Head.ID)
+	}
+}
+
+func TestRelaxedSplitKeepsClosestContacts(t *testing.T) {
+	routing := NewRoutingTable(GenerateID([]byte{0}))
+	for i := 0; i < MaxCapacity; i++ {
+		routing.Add(generateContactFrom("C0" + GenerateRandomID().String()[2:]))
+	}
+
+	// the bucket 1 does not contain our own id, but c is closer to us than any contact we know
+	c := generateContactFrom("80" + GenerateRandomID().String()[2:])
+	_, index, err := routing.Add(c)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
 	}
 
+	if len(routing.buckets) != 3 {
+		t.Fatalf("Expected %d buckets, but got %d\n", 3, len(routing.buckets))
+	}
+
+	if index != 1 || routing.buckets[1].Depth() != 2 || routing.buckets[2].Size() != MaxCapacity {
+		t.Errorf("Expected %s to be added to bucket 1 at depth 2, but got index %d\n", c.ID, index)
+	}
 }
 
 func TestAddContactToRoutingTableWithoutErrors(t *testing.T) {
@@ -81,7 +117,7 @@ func TestAddContactToRoutingTableWithoutErrors(t *testing.T) {
 		{
 			id1: "480F741BC1B397C54A54858E4E2A8840B2BC766B", // 0100100000001111011101000001101111000001101100111001011111000101010010100101010010000101100011100100111000101010100010000100000010110010101111000111011001101011
 			id2: "C80F741BC1B397C54A54858E4E2A8840B2BC766B", // 1100100000001111011101000001101111000001101100111001011111000101010010100101010010000101100011100100111000101010100010000100000010110010101111000111011001101011
-			out: 159,
+			out: 0,
 		},
 		{
 			id1: "480F741BC1B397C54A54858E4E2A8840B2BC766B",
@@ -91,7 +127,7 @@ func TestAddContactToRoutingTableWithoutErrors(t *testing.T) {
 		{
 			id1: "489887A2C81C7920911815BCD99D3F19AB3D633D",
 			id2: "DC84DABE02FB31B9011800635C03794213EBA1F0",
-			out: 159,
+			out: 0,
 		},
 	}
 
@@ -120,6 +156,20 @@ func TestAddContactToRoutingTableWithoutErrors(t *testing.T) {
 	}
 }
 
+func TestAddRejectsIDsOfOtherLength(t *testing.T) {
+	routing := NewRoutingTable(GenerateRandomID())
+
+	for _, id := range []ID{nil, {1, 2, 3}, GenerateRandomIDWithBits(BITS256)} {
+		if _, _, err := routing.Add(Contact{ID: id}); err != ErrInvalidIDLength {
+			t.Errorf("Expected error %s for %s, but got %v\n", ErrInvalidIDLength, id, err)
+		}
+	}
+
+	if routing.buckets[0].Size() != 0 {
+		t.Errorf("Expected no contacts to be added, but got %d\n", routing.buckets[0].Size())
+	}
+}
+
 func TestAddExistingContactToRoutingTable(t *testing.T) {
 	contact1 := generateContactFrom("480F741BC1B397C54A54858E4E2A8840B2BC766B")
 	contact2 := generateContactFrom("480F741BC1B397C54A54858E4E2A8840B2BC766B")
@@ -144,22 +194,32 @@ func TestAddExistingContactToRoutingTable(t *testing.T) {
 }
 
 func TestOrderOfVisits(t *testing.T) {
-	expected := []int{159, 158, 155, 147, 146, 145, 144, 142, 141, 140, 138, 132, 131, 129, 128, 127, 126, 120, 119, 117, 116, 113, 112, 111, 108, 106, 105, 104, 103, 102, 98, 96, 94, 91, 89, 86, 84, 82, 79, 74, 72, 71, 67, 66, 65, 62, 59, 58, 57, 53, 51, 49, 47, 43, 38, 31, 29, 28, 25, 23, 21, 20, 19, 18, 14, 13, 12, 10, 9, 6, 5, 3, 1, 0, 2, 4, 7, 8, 11, 15, 16, 17, 22, 24, 26, 27, 30, 32, 33, 34, 35, 36, 37, 39, 40, 41, 42, 44, 45, 46, 48, 50, 52, 54, 55, 56, 60, 61, 63, 64, 68, 69, 70, 73, 75, 76, 77, 78, 80, 81, 83, 85, 87, 88, 90, 92, 93, 95, 97, 99, 100, 101, 107, 109, 110, 114, 115, 118, 121, 122, 123, 124, 125, 130, 133, 134, 135, 136, 137, 139, 143, 148, 149, 150, 151, 152, 153, 154, 156, 157}
-	id, _ := From("C80F741BC1B397C54A54858E4E2A8840B2BC766B")
-	routing := NewRoutingTable(nil)
+	// our id 0010 and the buckets 000, 001, 01 and 1 in an address space of 4 bits
+	routing := NewRoutingTable(GenerateID([]byte{0x20}))
+	for _, depth := range []int{0, 1, 2} {
+		routing.split(routing.indexOf(routing.id))
+		if routing.buckets[0].Depth() != depth+1 {
+			t.Fatalf("Expected bucket 0 to be at depth %d, but got %d\n", depth+1, routing.buckets[0].Depth())
+		}
+	}
 
-	out := routing.determineOrderOfVisits(id)
+	expected := []string{"1", "000", "001", "01"}
+	out := routing.orderOfVisits(GenerateID([]byte{0x80}))
 
 	if len(expected) != len(out) {
-		t.Errorf("Expected %d but got %d\n", len(expected), len(out))
+		t.Fatalf("Expected %d but got %d\n", len(expected), len(out))
 	}
 
-	for i, x := range expected {
-		if out[i] != x {
-			t.Errorf("Expected %d at index %d, but got %d\n", x, i, out[i])
+	for i, prefix := range expected {
+		got := ""
+		for bit := 0; bit < out[i].Depth(); bit++ {
+			got += fmt.Sprint(out[i].prefix.GetBitAt(uint(bit)))
 		}
-	}
 
+		if got != prefix {
+			t.Errorf("Expected %s at index %d, but got %s\n", prefix, i, got)
+		}
+	}
 }
 
 func TestRoutingGet3closestContacts(t *testing.T) {
@@ -201,3 +261,178 @@ func TestRoutingGet3closestContacts(t *testing.T) {
 
 	}
 }
+
+func TestRemoveContactFromRoutingTable(t *testing.T) {
+	id, _ := From("489887A2C81C7920911815BCD99D3F19AB3D633D")
+	routing := NewRoutingTable(id)
+	contact := generateContactFrom("480F741BC1B397C54A54858E4E2A8840B2BC766B")
+
+	_, i, _ := routing.Add(contact)
+
+	if !routing.Remove(contact.ID) {
+		t.Fatalf("Expected contact %s to be removed\n", contact.ID)
+	}
+
+	if routing.buckets[i].Size() != 0 {
+		t.Errorf("Expected bucket size to be 0, but got %d\n", routing.buckets[i].Size())
+	}
+
+	if routing.Remove(contact.ID) {
+		t.Errorf("Expected contact %s not to be removed twice\n", contact.ID)
+	}
+}
+
+func TestFailEvictsContactFromRoutingTable(t *testing.T) {
+	id, _ := From("489887A2C81C7920911815BCD99D3F19AB3D633D")
+	routing := NewRoutingTable(id)
+	contact := generateContactFrom("480F741BC1B397C54A54858E4E2A8840B2BC766B")
+
+	_, i, _ := routing.Add(contact)
+
+	for n := 1; n < MaxFailures; n++ {
+		if routing.Fail(contact.ID) {
+			t.Fatalf("Expected contact to survive %d failures\n", n)
+		}
+	}
+
+	if !routing.Fail(contact.ID) {
+		t.Errorf("Expected contact to be evicted after %d failures\n", MaxFailures)
+	}
+
+	if routing.buckets[i].Size() != 0 {
+		t.Errorf("Expected bucket size to be 0, but got %d\n", routing.buckets[i].Size())
+	}
+}
+
+func TestRandomIDInBucket(t *testing.T) {
+	routing, _ := newRandomRoutingTable(rand.New(rand.NewSource(1)), 400)
+
+	for _, b := range routing.Buckets() {
+		for i := 0; i < 10; i++ {
+			out := b.randomID()
+			if got := routing.indexOf(out); got != b.Index() {
+				t.Errorf("Expected %s to fall into bucket %d, but got %d\n", out, b.Index(), got)
+			}
+		}
+	}
+}
+
+func TestClosestContactsMatchesBruteForce(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		routing, contacts := newRandomRoutingTable(r, 400)
+
+		for i := 0; i < 20; i++ {
+			target := make(ID, SIZE)
+			r.Read(target)
+			// half of the targets fall close to our own id, where the buckets are sparse
+			if i%2 == 0 {
+				target = randomIDNear(r, routing.ID(), r.Intn(BITS))
+			}
+
+			for _, n := range []int{1, ALPHA, K, 50, len(contacts) + 10} {
+				out := routing.ClosestContacts(target, n)
+				expected := bruteForceClosest(contacts, nil, target, n)
+
+				if len(out) != len(expected) {
+					t.Fatalf("seed %d: Expected %d contacts, but got %d\n", seed, len(expected), len(out))
+				}
+
+				for j := range expected {
+					if !out[j].ID.Equal(expected[j].ID) {
+						t.Fatalf("seed %d: Expected at index (%d) %s, but got %s\n", seed, j, expected[j].ID, out[j].ID)
+					}
+
+					if j > 0 && target.CompareDistanceTo(out[j-1].ID, out[j].ID) <= 0 {
+						t.Fatalf("seed %d: Expected contacts to be strictly sorted at index (%d)\n", seed, j)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestClosestContactsWith256BitIDs(t *testing.T) {
+	routing := NewRoutingTable(IDFromKey([]byte("self")))
+
+	contacts := make([]Contact, 0)
+	for i := 0; i < 300; i++ {
+		c := Contact{ID: IDFromKey([]byte(fmt.Sprint("contact ", i))), IP: net.IPv4(127, 0, 0, 1), Port: 3000}
+		if _, _, err := routing.Add(c); err == nil {
+			contacts = append(contacts, c)
+		}
+	}
+
+	if len(routing.buckets) < 2 {
+		t.Fatalf("Expected the routing table to be split, but got %d buckets\n", len(routing.buckets))
+	}
+
+	for i := 0; i < 20; i++ {
+		target := IDFromKey([]byte(fmt.Sprint("target ", i)))
+		out := routing.ClosestContacts(target, K)
+		expected := bruteForceClosest(contacts, nil, target, K)
+
+		if len(out) != len(expected) {
+			t.Fatalf("Expected %d contacts, but got %d\n", len(expected), len(out))
+		}
+
+		for j := range expected {
+			if !out[j].ID.Equal(expected[j].ID) {
+				t.Fatalf("Expected at index (%d) %s, but got %s\n", j, expected[j].ID, out[j].ID)
+			}
+		}
+	}
+}
+
+func TestBucketClosestContactsKeepsEveryContact(t *testing.T) {
+	bucket := NewKBucket(0)
+	target := GenerateRandomID()
+	for i := 0; i < MaxCapacity; i++ {
+		bucket.Insert(generateRandomContact())
+	}
+
+	out := bucket.getXClosestContacts(MaxCapacity, target)
+	if len(out) != MaxCapacity {
+		t.Fatalf("Expected %d contacts, but got %d\n", MaxCapacity, len(out))
+	}
+
+	for i := 1; i < len(out); i++ {
+		if target.CompareDistanceTo(out[i-1].ID, out[i].ID) <= 0 {
+			t.Errorf("Expected contacts to be strictly sorted at index (%d)\n", i)
+		}
+	}
+}
+
+// newRandomRoutingTable fills a routing table with random contacts, spread over many
+// buckets, and returns the table with the contacts it accepted
+func newRandomRoutingTable(r *rand.Rand, n int) (*RoutingTable, []Contact) {
+	id := make(ID, SIZE)
+	r.Read(id)
+	routing := NewRoutingTable(id)
+
+	contacts := make([]Contact, 0, n)
+	for i := 0; i < n; i++ {
+		c := Contact{ID: randomIDNear(r, id, r.Intn(BITS)), IP: net.IPv4(127, 0, 0, 1), Port: 3000}
+		if _, _, err := routing.Add(c); err == nil {
+			contacts = append(contacts, c)
+		}
+	}
+
+	return routing, contacts
+}
+
+// randomIDNear returns a random id that shares the first bit bits with id and differs in the next one
+func randomIDNear(r *rand.Rand, id ID, bit int) ID {
+	out := make(ID, SIZE)
+	r.Read(out)
+
+	for i := 0; i < bit; i++ {
+		mask := byte(0x80 >> uint(i%8))
+		out[i/8] = out[i/8]&^mask | id[i/8]&mask
+	}
+
+	mask := byte(0x80 >> uint(bit%8))
+	out[bit/8] = out[bit/8]&^mask | ^id[bit/8]&mask
+
+	return out
+}
@@ -0,0 +1,214 @@
+package gokad
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// SendPing checks whether c is online
+func (dht *DHT) SendPing(ctx context.Context, c Contact) error {
+	_, err := dht.call(ctx, c, PING, nil)
+
+	return err
+}
+
+// SendFindNode asks c for the k closest contacts it knows to id
+func (dht *DHT) SendFindNode(ctx context.Context, c Contact, id ID) ([]Contact, error) {
+	if err := dht.checkID(id); err != nil {
+		return nil, err
+	}
+
+	res, err := dht.call(ctx, c, FIND_NODE, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return deserializeContacts(res.Payload, len(dht.ID))
+}
+
+// SendStore asks c to store the host port record ip:port under key for the value ttl
+func (dht *DHT) SendStore(ctx context.Context, c Contact, key ID, ip net.IP, port int) error {
+	return dht.sendStore(ctx, c, key, Provider{Publisher: dht.ID, Value: HostPort{Host: ip, Port: port}.Value()}, dht.valueTTL)
+}
+
+// sendStore asks c to store the value of pr under key on behalf of its publisher
+func (dht *DHT) sendStore(ctx context.Context, c Contact, key ID, pr Provider, ttl time.Duration) error {
+	if err := dht.checkID(key); err != nil {
+		return err
+	}
+
+	_, err := dht.call(ctx, c, STORE, encodeStoreRequest(key, pr.Publisher, pr.Value, ttl))
+
+	return err
+}
+
+// SendFindValue asks c for the values stored under key. If c does not hold any value,
+// the k closest contacts c knows to key are returned instead.
+// Values larger than the max value size are dropped
+func (dht *DHT) SendFindValue(ctx context.Context, c Contact, key ID) ([]Contact, []Provider, error) {
+	if err := dht.checkID(key); err != nil {
+		return nil, nil, err
+	}
+
+	res, err := dht.call(ctx, c, FIND_VALUE, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contacts, providers, err := decodeFindValueResponse(res.Payload, len(dht.ID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accepted := providers[:0]
+	for _, pr := range providers {
+		if len(pr.Value) <= dht.maxValueSize {
+			accepted = append(accepted, pr)
+		}
+	}
+
+	return contacts, accepted, nil
+}
+
+// call sends a request through the transport. Every contact that responds is added to the routing table.
+// Contacts that fail to respond are removed after MaxFailures consecutive failures
+func (dht *DHT) call(ctx context.Context, c Contact, t MessageType, payload []byte) (Message, error) {
+	if dht.transport == nil {
+		return Message{}, ErrNoTransport
+	}
+
+	if c.ID != nil {
+		dht.routingTable.contacted(c.ID)
+	}
+
+	rpcCtx, cancel := context.WithTimeout(ctx, dht.rpcTimeout)
+	defer cancel()
+
+	res, err := dht.transport.Call(rpcCtx, c, NewRequest(t, dht.ID, payload))
+	if err == nil && len(res.SenderID) != len(dht.ID) {
+		err = ErrMalformedMessage
+	}
+
+	if err != nil {
+		// neither the caller giving up nor the transport being closed is the contact's fault
+		if ctx.Err() == nil && !errors.Is(err, ErrTransportClosed) {
+			dht.failed(c)
+		}
+		return Message{}, err
+	}
+
+	dht.seen(Contact{ID: res.SenderID, IP: c.IP, Port: c.Port})
+
+	return res, nil
+}
+
+// handleRequest serves the requests delivered by the transport.
+// Every contact that sends a request is added to the routing table.
+// Requests from nodes with ids of another length are rejected
+func (dht *DHT) handleRequest(from Contact, req Message) (Message, error) {
+	var payload []byte
+
+	n := len(dht.ID)
+	if len(req.SenderID) != n {
+		return Message{}, ErrMalformedMessage
+	}
+
+	switch req.Type {
+	case PING:
+	case STORE:
+		key, publisher, v, ttl, err := decodeStoreRequest(req.Payload, n)
+		if err != nil {
+			return Message{}, err
+		}
+		if len(v) > dht.maxValueSize {
+			return Message{}, ErrValueTooLarge
+		}
+		if reduced := dht.ttlFor(key); reduced < ttl {
+			ttl = reduced
+		}
+		if err := dht.storeValue(key, Provider{Publisher: publisher, Value: v}, ttl); err != nil {
+			return Message{}, err
+		}
+	case FIND_NODE:
+		id, err := decodeIDPayload(req.Payload, n)
+		if err != nil {
+			return Message{}, err
+		}
+		payload = encodeContacts(dht.FindNode(id), payloadSize(n))
+	case FIND_VALUE:
+		key, err := decodeIDPayload(req.Payload, n)
+		if err != nil {
+			return Message{}, err
+		}
+		contacts, providers := dht.FindValue(key)
+		payload = encodeFindValueResponse(contacts, providers, n)
+	default:
+		return Message{}, ErrMalformedMessage
+	}
+
+	dht.seen(from)
+
+	return NewResponse(req, dht.ID, payload), nil
+}
+
+// seen records that c was heard from.
+// If the bucket c belongs to is at capacity, c goes into the bucket's replacement
+// cache and the head of the bucket is pinged in the background
+func (dht *DHT) seen(c Contact) {
+	if c.ID.Equal(dht.ID) {
+		return
+	}
+
+	c.LastSeen = time.Now()
+
+	_, _, err := dht.routingTable.Add(c)
+
+	var full *BucketFullError
+	if !errors.As(err, &full) {
+		return
+	}
+	head := full.Head
+
+	dht.mu.Lock()
+	defer dht.mu.Unlock()
+
+	// the head is already being pinged on behalf of another contact
+	if dht.pinging[head.ID.String()] {
+		return
+	}
+
+	dht.pinging[head.ID.String()] = true
+	go dht.pingHead(head)
+}
+
+// failed records that an RPC to c failed
+func (dht *DHT) failed(c Contact) {
+	if c.ID == nil {
+		return
+	}
+
+	dht.routingTable.Fail(c.ID)
+}
+
+// pingHead pings the head of a full bucket. If the head replies, it was moved to the
+// tail of its bucket when the reply was seen. Otherwise the head is evicted and the
+// most recently seen contact of the bucket's replacement cache takes its place.
+// The head is kept if the DHT is closed before it could reply
+// @Source: Kademlia: A Peer-to-peer Information System Based on the XOR Metric
+// https://pdos.csail.mit.edu/~petar/papers/maymounkov-kademlia-lncs.pdf
+func (dht *DHT) pingHead(head Contact) {
+	ctx, cancel := dht.closeContext()
+	defer cancel()
+
+	err := dht.SendPing(ctx, head)
+
+	dht.mu.Lock()
+	delete(dht.pinging, head.ID.String())
+	dht.mu.Unlock()
+
+	if err != nil && ctx.Err() == nil && !errors.Is(err, ErrTransportClosed) {
+		dht.routingTable.Remove(head.ID)
+	}
+}
@@ -0,0 +1,162 @@
+package gokad
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFullBucketEvictsUnresponsiveHead(t *testing.T) {
+	network := NewMemoryNetwork()
+	local := newMemoryNodeWithID(network, GenerateID([]byte{0}))
+	addNearContacts(local.RoutingTable())
+
+	// fill the farthest bucket with contacts that are not attached to the network
+	dead := make([]Contact, MaxCapacity)
+	for i := range dead {
+		dead[i] = generateContactFrom("80" + GenerateRandomID().String()[2:])
+		local.RoutingTable().Add(dead[i])
+	}
+	bucket := local.RoutingTable().bucketOf(dead[0].ID)
+
+	newcomer := newMemoryNodeWithID(network, GenerateID([]byte{128, 1}))
+	if err := newcomer.SendPing(context.Background(), contactOf(local)); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	waitFor(t, func() bool {
+		return bucketContains(bucket, newcomer.ID)
+	})
+
+	if bucketContains(bucket, dead[0].ID) {
+		t.Errorf("Expected head %s to be evicted\n", dead[0].ID)
+	}
+
+	if bucket.Size() != MaxCapacity {
+		t.Errorf("Expected bucket size to be %d, but got %d\n", MaxCapacity, bucket.Size())
+	}
+
+	if !bucket.Tail().ID.Equal(newcomer.ID) {
+		t.Errorf("Expected Tail to be %s but got %s\n", newcomer.ID, bucket.Tail().ID)
+	}
+}
+
+// addNearContacts fills the routing table of the node with id 0 with K contacts in the
+// closer half of the id space, so that a full bucket in the other half can not be split
+func addNearContacts(routing *RoutingTable) {
+	for i := 0; i < K; i++ {
+		routing.Add(generateContactFrom("01" + GenerateRandomID().String()[2:]))
+	}
+}
+
+func TestFullBucketKeepsResponsiveHead(t *testing.T) {
+	network := NewMemoryNetwork()
+	local := newMemoryNodeWithID(network, GenerateID([]byte{0}))
+	addNearContacts(local.RoutingTable())
+
+	live := make([]*DHT, MaxCapacity)
+	for i := range live {
+		live[i] = newMemoryNodeWithID(network, GenerateID([]byte{128, byte(i + 1)}))
+		local.RoutingTable().Add(contactOf(live[i]))
+	}
+	bucket := local.RoutingTable().bucketOf(live[0].ID)
+
+	newcomer := newMemoryNodeWithID(network, GenerateID([]byte{128, 255}))
+	if err := newcomer.SendPing(context.Background(), contactOf(local)); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	waitFor(t, func() bool {
+		return bucket.Tail().ID.Equal(live[0].ID)
+	})
+
+	if bucketContains(bucket, newcomer.ID) {
+		t.Errorf("Expected newcomer %s not to be added\n", newcomer.ID)
+	}
+
+	if !bucket.Head().ID.Equal(live[1].ID) {
+		t.Errorf("Expected Head to be %s but got %s\n", live[1].ID, bucket.Head().ID)
+	}
+}
+
+func TestFailingContactIsRemoved(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	remote, c := newMemoryNode(network)
+	local.RoutingTable().Add(c)
+	remote.transport.Close()
+
+	for i := 0; i < MaxFailures; i++ {
+		if err := local.SendPing(context.Background(), c); err == nil {
+			t.Fatalf("Expected ping to fail\n")
+		}
+	}
+
+	if got := local.FindNode(c.ID); len(got) != 0 {
+		t.Errorf("Expected %s to be removed, but got %v\n", c.ID, got)
+	}
+}
+
+func TestClosingDoesNotFailContacts(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	_, c := newMemoryNode(network)
+	local.RoutingTable().Add(c)
+	local.Close()
+
+	for i := 0; i < MaxFailures; i++ {
+		if err := local.SendPing(context.Background(), c); err == nil {
+			t.Fatalf("Expected ping to fail\n")
+		}
+	}
+
+	if got := local.FindNode(c.ID); len(got) != 1 {
+		t.Errorf("Expected %s to be kept, but got %v\n", c.ID, got)
+	}
+}
+
+func TestClosingKeepsPingedHead(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	_, head := newMemoryNode(network)
+	local.RoutingTable().Add(head)
+	local.Close()
+
+	local.pingHead(head)
+
+	if got := local.FindNode(head.ID); len(got) != 1 {
+		t.Errorf("Expected head %s to be kept, but got %v\n", head.ID, got)
+	}
+}
+
+func newMemoryNodeWithID(network *MemoryNetwork, id ID) *DHT {
+	return DHTFrom(DHTConfig{ID: id, Transport: network.NewTransport()})
+}
+
+// contactOf returns the contact other nodes reach dht at
+func contactOf(dht *DHT) Contact {
+	addr := dht.transport.(*MemoryTransport).Addr()
+	return Contact{ID: dht.ID, IP: addr.IP, Port: addr.Port}
+}
+
+// bucketContains reports whether the bucket holds a contact with the given id
+func bucketContains(b *KBucket, id ID) bool {
+	var found bool
+	b.Walk(func(c Contact) bool {
+		found = c.ID.Equal(id)
+		return found
+	})
+
+	return found
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Condition not met within a second\n")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
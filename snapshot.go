@@ -0,0 +1,276 @@
+package gokad
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// Errors
+var ErrInvalidSnapshot = errors.New("Invalid Routing Table Snapshot")
+
+// snapshotVersion is the version of the snapshot format written by WriteTo
+const snapshotVersion = 1
+
+// Snapshot format (version 1). All integers are big endian, times are unix nanoseconds
+// (0 for the zero time).
+//
+//   header:  1 byte version <- 1 byte id length n <- n bytes id <- 1 byte k <- 2 bytes bucket count
+//   bucket:  1 byte depth <- n bytes prefix <- 8 bytes last lookup <- 1 byte contact count
+//            <- contacts <- 1 byte replacement count <- replacements
+//   contact: 1 byte length l <- l bytes Contact.Serialize <- 8 bytes last seen
+//            <- 8 bytes last contacted <- 1 byte failures
+//
+// Buckets are written closest to our own id first. Contacts and replacements are written in
+// bucket order, from head to tail and from least to most recently seen respectively.
+
+// WriteTo writes a snapshot of the routing table, including its own id and every
+// bucket's range and contacts with their metadata, to w. It implements io.WriterTo
+func (r *RoutingTable) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	out := make([]byte, 0)
+	out = append(out, snapshotVersion, byte(len(r.id)))
+	out = append(out, r.id...)
+	out = append(out, byte(r.k))
+	out = appendUint16(out, uint16(len(r.buckets)))
+
+	for _, b := range r.buckets {
+		out = b.appendSnapshot(out)
+	}
+	r.mu.RUnlock()
+
+	n, err := w.Write(out)
+
+	return int64(n), err
+}
+
+// ReadRoutingTable restores a routing table from a snapshot written by RoutingTable.WriteTo.
+// Pass it to DHTFrom through DHTConfig.RoutingTable to warm restart a DHT
+func ReadRoutingTable(r io.Reader) (*RoutingTable, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+
+	if header[0] != snapshotVersion || header[1] == 0 {
+		return nil, ErrInvalidSnapshot
+	}
+
+	id := make(ID, header[1])
+	if _, err := io.ReadFull(br, id); err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+
+	k, err := br.ReadByte()
+	if err != nil || k == 0 {
+		return nil, ErrInvalidSnapshot
+	}
+
+	count, err := readUint16(br)
+	if err != nil {
+		return nil, err
+	}
+
+	table := NewRoutingTableWithK(id, int(k))
+
+	filled := make(map[*KBucket]bool)
+	for i := 0; i < int(count); i++ {
+		depth, err := br.ReadByte()
+		if err != nil || int(depth) > id.Bits() {
+			return nil, ErrInvalidSnapshot
+		}
+
+		prefix := make(ID, len(id))
+		if _, err := io.ReadFull(br, prefix); err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+
+		b, err := table.carve(prefix, int(depth), filled)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := b.readSnapshot(br); err != nil {
+			return nil, err
+		}
+		filled[b] = true
+	}
+
+	if len(table.buckets) != int(count) {
+		return nil, ErrInvalidSnapshot
+	}
+
+	return table, nil
+}
+
+// carve splits the buckets of the routing table until there is a bucket with the given
+// prefix and depth and returns it. The range of the bucket must not overlap any filled bucket
+func (r *RoutingTable) carve(prefix ID, depth int, filled map[*KBucket]bool) (*KBucket, error) {
+	for {
+		index := r.indexOf(prefix)
+		b := r.buckets[index]
+		if filled[b] || b.depth > depth {
+			return nil, ErrInvalidSnapshot
+		}
+
+		if b.depth == depth {
+			return b, nil
+		}
+
+		r.split(index)
+	}
+}
+
+func (b *KBucket) appendSnapshot(out []byte) []byte {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	out = append(out, byte(b.depth))
+	out = append(out, b.prefix...)
+	out = appendTime(out, b.lastLookup)
+
+	out = append(out, byte(b.size))
+	b.walk(func(c Contact) bool {
+		out = appendContact(out, c)
+		return false
+	})
+
+	out = append(out, byte(len(b.replacements)))
+	for _, c := range b.replacements {
+		out = appendContact(out, c)
+	}
+
+	return out
+}
+
+func (b *KBucket) readSnapshot(r *bufio.Reader) error {
+	n := len(b.prefix)
+	lastLookup, err := readTime(r)
+	if err != nil {
+		return err
+	}
+
+	contacts, err := readContacts(r, b.capacity, n)
+	if err != nil {
+		return err
+	}
+
+	replacements, err := readContacts(r, MaxReplacements, n)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range append(contacts, replacements...) {
+		if !b.covers(c.ID) {
+			return ErrInvalidSnapshot
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastLookup = lastLookup
+	for _, c := range contacts {
+		b.add(c)
+	}
+	b.replacements = replacements
+
+	return nil
+}
+
+func appendContact(out []byte, c Contact) []byte {
+	out = appendSerializedContact(out, c)
+	out = appendTime(out, c.LastSeen)
+	out = appendTime(out, c.LastContacted)
+
+	return append(out, byte(c.failures))
+}
+
+// readContacts reads a count prefixed list of at most max contacts with ids of n bytes
+func readContacts(r *bufio.Reader, max int, n int) ([]Contact, error) {
+	count, err := r.ReadByte()
+	if err != nil || int(count) > max {
+		return nil, ErrInvalidSnapshot
+	}
+
+	out := make([]Contact, 0, count)
+	for i := 0; i < int(count); i++ {
+		length, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+
+		s := make([]byte, length)
+		if _, err := io.ReadFull(r, s); err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+
+		c, err := deserializeContact(s, n)
+		if err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+
+		if c.LastSeen, err = readTime(r); err != nil {
+			return nil, err
+		}
+
+		if c.LastContacted, err = readTime(r); err != nil {
+			return nil, err
+		}
+
+		failures, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+		c.failures = int(failures)
+
+		out = append(out, c)
+	}
+
+	return out, nil
+}
+
+func appendUint16(out []byte, v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+
+	return append(out, b...)
+}
+
+func readUint16(r io.Reader) (uint16, error) {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, ErrInvalidSnapshot
+	}
+
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func appendTime(out []byte, t time.Time) []byte {
+	var nanos int64
+	if !t.IsZero() {
+		nanos = t.UnixNano()
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(nanos))
+
+	return append(out, b...)
+}
+
+func readTime(r io.Reader) (time.Time, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return time.Time{}, ErrInvalidSnapshot
+	}
+
+	nanos := int64(binary.BigEndian.Uint64(b))
+	if nanos == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Unix(0, nanos), nil
+}
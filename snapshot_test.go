@@ -0,0 +1,164 @@
+package gokad
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRoutingTableSnapshotRoundTrip(t *testing.T) {
+	id := GenerateID([]byte{0})
+	routing := NewRoutingTable(id)
+
+	contacts := make([]Contact, MaxCapacity+2)
+	for i := range contacts {
+		contacts[i] = generateContactFrom("80" + GenerateRandomID().String()[2:])
+		contacts[i].LastSeen = time.Unix(0, int64(i+1))
+		routing.Add(contacts[i])
+	}
+
+	near := Contact{ID: GenerateID([]byte{0, 0, 1}), IP: net.ParseIP("2001:db8::1"), Port: 9000}
+	routing.Add(near)
+	routing.contacted(near.ID)
+	routing.Fail(near.ID)
+
+	var buf bytes.Buffer
+	n, err := routing.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if int(n) != buf.Len() {
+		t.Errorf("Expected %d bytes to be reported, but got %d\n", buf.Len(), n)
+	}
+
+	restored, err := ReadRoutingTable(&buf)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if !restored.ID().Equal(id) {
+		t.Errorf("Expected id %s, but got %s\n", id, restored.ID())
+	}
+
+	for i, b := range routing.buckets {
+		r := restored.buckets[i]
+		if r.String() != b.String() {
+			t.Errorf("Expected bucket %d to be %s, but got %s\n", i, b, r)
+		}
+
+		if !r.LastLookup().Equal(b.LastLookup()) {
+			t.Errorf("Expected bucket %d last lookup %s, but got %s\n", i, b.LastLookup(), r.LastLookup())
+		}
+
+		if len(r.Replacements()) != len(b.Replacements()) {
+			t.Errorf("Expected bucket %d to have %d replacements, but got %d\n", i, len(b.Replacements()), len(r.Replacements()))
+		}
+	}
+
+	far := restored.bucketOf(contacts[0].ID)
+	if !far.Head().LastSeen.Equal(contacts[0].LastSeen) {
+		t.Errorf("Expected last seen %s, but got %s\n", contacts[0].LastSeen, far.Head().LastSeen)
+	}
+
+	got := restored.bucketOf(near.ID).Head()
+	if !got.IP.Equal(near.IP) || got.Port != near.Port || got.LastContacted.IsZero() || got.failures != 1 {
+		t.Errorf("Expected %s:%d with metadata, but got %s:%d %s %d\n", near.IP, near.Port, got.IP, got.Port, got.LastContacted, got.failures)
+	}
+}
+
+func TestReadCorruptedSnapshot(t *testing.T) {
+	routing := NewRoutingTable(GenerateRandomID())
+	routing.Add(generateRandomContact())
+
+	var buf bytes.Buffer
+	routing.WriteTo(&buf)
+	valid := buf.Bytes()
+
+	badVersion := append([]byte{}, valid...)
+	badVersion[0] = snapshotVersion + 1
+
+	// the whole id space twice
+	empty := []byte{0}
+	empty = append(empty, make([]byte, SIZE)...)
+	empty = appendTime(empty, time.Now())
+	empty = append(empty, 0, 0)
+
+	overlap := append([]byte{snapshotVersion, SIZE}, GenerateRandomID()...)
+	overlap = append(overlap, K)
+	overlap = appendUint16(overlap, 2)
+	overlap = append(overlap, empty...)
+	overlap = append(overlap, empty...)
+
+	cases := [][]byte{
+		{},
+		valid[:10],
+		valid[:len(valid)-1],
+		badVersion,
+		overlap,
+	}
+
+	for i, c := range cases {
+		if _, err := ReadRoutingTable(bytes.NewReader(c)); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Errorf("Case %d: Expected error %s, but got %v\n", i, ErrInvalidSnapshot, err)
+		}
+	}
+}
+
+func TestSnapshotKeepsKAndIDLength(t *testing.T) {
+	id := GenerateRandomIDWithBits(256)
+	routing := NewRoutingTableWithK(id, 4)
+	for i := 0; i < 20; i++ {
+		routing.Add(Contact{ID: GenerateRandomIDWithBits(256), IP: net.IPv4(127, 0, 0, 1), Port: 3000})
+	}
+
+	var buf bytes.Buffer
+	routing.WriteTo(&buf)
+
+	restored, err := ReadRoutingTable(&buf)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if !restored.ID().Equal(id) || restored.K() != 4 {
+		t.Errorf("Expected id %s and k %d, but got %s and %d\n", id, 4, restored.ID(), restored.K())
+	}
+
+	if len(restored.buckets) != len(routing.buckets) {
+		t.Fatalf("Expected %d buckets, but got %d\n", len(routing.buckets), len(restored.buckets))
+	}
+
+	for i, b := range routing.buckets {
+		if r := restored.buckets[i]; r.String() != b.String() || r.Capacity() != 4 {
+			t.Errorf("Expected bucket %d to be %s, but got %s\n", i, b, r)
+		}
+	}
+}
+
+func TestDHTWarmRestart(t *testing.T) {
+	dht := NewDHT()
+	c := generateRandomContact()
+	dht.RoutingTable().Add(c)
+
+	var buf bytes.Buffer
+	if _, err := dht.RoutingTable().WriteTo(&buf); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	routing, err := ReadRoutingTable(&buf)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	restarted := DHTFrom(DHTConfig{RoutingTable: routing})
+
+	if !restarted.ID.Equal(dht.ID) {
+		t.Errorf("Expected id %s, but got %s\n", dht.ID, restarted.ID)
+	}
+
+	if got := restarted.FindNode(c.ID); len(got) != 1 || !got[0].ID.Equal(c.ID) {
+		t.Errorf("Expected %s to be known after the restart, but got %v\n", c.ID, got)
+	}
+}
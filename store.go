@@ -0,0 +1,209 @@
+package gokad
+
+import (
+	"sync"
+	"time"
+)
+
+// Record is a value published under a key by a publisher together with the time
+// it expires at and the time it was last stored or replicated
+type Record struct {
+	Key        ID
+	Publisher  ID
+	Value      Value
+	Expires    time.Time
+	Replicated time.Time
+}
+
+// expired reports whether the record expired at now
+func (r Record) expired(now time.Time) bool {
+	return !r.Expires.After(now)
+}
+
+// Provider returns the publisher and value of the record
+func (r Record) Provider() Provider {
+	return Provider{Publisher: r.Publisher, Value: r.Value}
+}
+
+// Store holds the records of a DHT. A key holds one record per publisher.
+// Implementations must be safe for concurrent use
+type Store interface {
+	// Put stores r, replacing the record r.Publisher stored under r.Key before
+	Put(r Record) error
+	// Get returns the records stored under key. Expired records are not returned
+	Get(key ID) ([]Record, error)
+	// Delete removes the record publisher stored under key. Deleting a missing record is not an error
+	Delete(key ID, publisher ID) error
+	// MarkReplicated sets the time the record publisher stored under key was last replicated,
+	// leaving its value and expiry time untouched. Marking a missing record is not an error
+	MarkReplicated(key ID, publisher ID, at time.Time) error
+	// Iterate calls fn for every record that has not expired until fn returns true.
+	// fn must not call back into the store
+	Iterate(fn func(r Record) bool) error
+	// Expire removes every record that expired at now and returns how many were removed
+	Expire(now time.Time) (int, error)
+}
+
+// records maps a key to the records stored under it by publisher
+type records map[string]map[string]Record
+
+func (rs records) put(r Record) {
+	key := r.Key.String()
+	if rs[key] == nil {
+		rs[key] = make(map[string]Record)
+	}
+
+	rs[key][r.Publisher.String()] = r
+}
+
+func (rs records) get(key ID, now time.Time) []Record {
+	out := make([]Record, 0, len(rs[key.String()]))
+	for _, r := range rs[key.String()] {
+		if !r.expired(now) {
+			out = append(out, r)
+		}
+	}
+
+	return out
+}
+
+// delete removes the record and reports whether there was one
+func (rs records) delete(key ID, publisher ID) bool {
+	byPublisher, ok := rs[key.String()]
+	if !ok {
+		return false
+	}
+
+	if _, ok := byPublisher[publisher.String()]; !ok {
+		return false
+	}
+
+	delete(byPublisher, publisher.String())
+	if len(byPublisher) == 0 {
+		delete(rs, key.String())
+	}
+
+	return true
+}
+
+// markReplicated sets the replicated time of the record and reports whether there was one
+func (rs records) markReplicated(key ID, publisher ID, at time.Time) bool {
+	r, ok := rs[key.String()][publisher.String()]
+	if !ok {
+		return false
+	}
+
+	r.Replicated = at
+	rs[key.String()][publisher.String()] = r
+
+	return true
+}
+
+func (rs records) iterate(now time.Time, fn func(r Record) bool) {
+	for _, byPublisher := range rs {
+		for _, r := range byPublisher {
+			if r.expired(now) {
+				continue
+			}
+
+			if fn(r) {
+				return
+			}
+		}
+	}
+}
+
+func (rs records) expire(now time.Time) int {
+	removed := 0
+	for key, byPublisher := range rs {
+		for publisher, r := range byPublisher {
+			if r.expired(now) {
+				delete(byPublisher, publisher)
+				removed++
+			}
+		}
+
+		if len(byPublisher) == 0 {
+			delete(rs, key)
+		}
+	}
+
+	return removed
+}
+
+func (rs records) len() int {
+	n := 0
+	for _, byPublisher := range rs {
+		n += len(byPublisher)
+	}
+
+	return n
+}
+
+// MemoryStore is a Store that keeps its records in memory
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records records
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(records)}
+}
+
+func (s *MemoryStore) Put(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records.put(r)
+
+	return nil
+}
+
+func (s *MemoryStore) Get(key ID) ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.records.get(key, time.Now()), nil
+}
+
+func (s *MemoryStore) Delete(key ID, publisher ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records.delete(key, publisher)
+
+	return nil
+}
+
+func (s *MemoryStore) MarkReplicated(key ID, publisher ID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records.markReplicated(key, publisher, at)
+
+	return nil
+}
+
+func (s *MemoryStore) Iterate(fn func(r Record) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.records.iterate(time.Now(), fn)
+
+	return nil
+}
+
+func (s *MemoryStore) Expire(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.records.expire(now), nil
+}
+
+// Len returns the number of records in the store, including expired ones not yet removed
+func (s *MemoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.records.len()
+}
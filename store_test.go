@@ -0,0 +1,281 @@
+package gokad
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newStores(t *testing.T) map[string]Store {
+	file, err := OpenFileStore(filepath.Join(t.TempDir(), "values"))
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"file":   file,
+	}
+}
+
+func generateRecord(ttl time.Duration) Record {
+	return Record{
+		Key:        GenerateRandomID(),
+		Publisher:  GenerateRandomID(),
+		Value:      Value("a stored value"),
+		Expires:    time.Now().Add(ttl),
+		Replicated: time.Now(),
+	}
+}
+
+func TestStorePutGetDelete(t *testing.T) {
+	for name, store := range newStores(t) {
+		r := generateRecord(time.Hour)
+		if err := store.Put(r); err != nil {
+			t.Fatalf("%s: Expected error to be nil, but got %s\n", name, err)
+		}
+
+		got, err := store.Get(r.Key)
+		if err != nil || len(got) != 1 {
+			t.Fatalf("%s: Expected record to be found, but got %d records %v\n", name, len(got), err)
+		}
+
+		if !bytes.Equal(got[0].Value, r.Value) || !got[0].Publisher.Equal(r.Publisher) {
+			t.Errorf("%s: Expected value %q, but got %q\n", name, r.Value, got[0].Value)
+		}
+
+		if err := store.Delete(r.Key, r.Publisher); err != nil {
+			t.Fatalf("%s: Expected error to be nil, but got %s\n", name, err)
+		}
+
+		if got, _ := store.Get(r.Key); len(got) != 0 {
+			t.Errorf("%s: Expected record to be deleted\n", name)
+		}
+	}
+}
+
+func TestStoreSkipsExpiredRecords(t *testing.T) {
+	for name, store := range newStores(t) {
+		live := generateRecord(time.Hour)
+		expired := generateRecord(-time.Second)
+		store.Put(live)
+		store.Put(expired)
+
+		if got, _ := store.Get(expired.Key); len(got) != 0 {
+			t.Errorf("%s: Expected expired record not to be found\n", name)
+		}
+
+		count := 0
+		store.Iterate(func(r Record) bool {
+			count++
+			return false
+		})
+
+		if count != 1 {
+			t.Errorf("%s: Expected to iterate over %d record, but got %d\n", name, 1, count)
+		}
+
+		removed, err := store.Expire(time.Now())
+		if err != nil {
+			t.Fatalf("%s: Expected error to be nil, but got %s\n", name, err)
+		}
+
+		if removed != 1 {
+			t.Errorf("%s: Expected %d record to be removed, but got %d\n", name, 1, removed)
+		}
+	}
+}
+
+func TestStoreMarkReplicatedKeepsValue(t *testing.T) {
+	for name, store := range newStores(t) {
+		old := generateRecord(time.Hour)
+		store.Put(old)
+
+		// the publisher stores a new value while the old one is being replicated
+		r := old
+		r.Value = Value("new value")
+		r.Expires = time.Now().Add(2 * time.Hour)
+		store.Put(r)
+
+		at := time.Now().Add(time.Minute)
+		if err := store.MarkReplicated(old.Key, old.Publisher, at); err != nil {
+			t.Fatalf("%s: Expected error to be nil, but got %s\n", name, err)
+		}
+
+		got, _ := store.Get(r.Key)
+		if len(got) != 1 || !bytes.Equal(got[0].Value, r.Value) || !got[0].Expires.Equal(r.Expires) {
+			t.Fatalf("%s: Expected the new value to be kept, but got %v\n", name, got)
+		}
+
+		if !got[0].Replicated.Equal(at) {
+			t.Errorf("%s: Expected replicated time %s, but got %s\n", name, at, got[0].Replicated)
+		}
+
+		if err := store.MarkReplicated(GenerateRandomID(), r.Publisher, at); err != nil {
+			t.Errorf("%s: Expected error to be nil for a missing record, but got %s\n", name, err)
+		}
+	}
+}
+
+func TestFileStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	live := generateRecord(time.Hour)
+	store.Put(live)
+	store.Put(generateRecord(50 * time.Millisecond))
+
+	time.Sleep(60 * time.Millisecond)
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if n := reopened.records.len(); n != 1 {
+		t.Errorf("Expected expired record to be dropped, but got %d records\n", n)
+	}
+
+	records, err := reopened.Get(live.Key)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected record to be found, but got %d records %v\n", len(records), err)
+	}
+
+	got := records[0]
+	if !got.Publisher.Equal(live.Publisher) || !got.Expires.Equal(live.Expires) || !got.Replicated.Equal(live.Replicated) {
+		t.Errorf("Expected times %s %s, but got %s %s\n", live.Expires, live.Replicated, got.Expires, got.Replicated)
+	}
+}
+
+func TestOpenCorruptFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values")
+	if err := ioutil.WriteFile(path, []byte{fileStoreVersion, 0xff, 1, 2, 3}, 0644); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if _, err := OpenFileStore(path); !errors.Is(err, ErrCorruptStore) {
+		t.Errorf("Expected error %s, but got %v\n", ErrCorruptStore, err)
+	}
+}
+
+func TestFileStoreDropsTornEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values")
+	store, _ := OpenFileStore(path)
+	first := generateRecord(time.Hour)
+	store.Put(first)
+	store.Put(generateRecord(time.Hour))
+	store.Close()
+
+	// a crash while the second record was appended
+	b, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, b[:len(b)-3], 0644)
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if n := reopened.records.len(); n != 1 {
+		t.Fatalf("Expected %d record, but got %d\n", 1, n)
+	}
+
+	if got, _ := reopened.Get(first.Key); len(got) != 1 {
+		t.Errorf("Expected first record to be kept\n")
+	}
+
+	// changes are appended behind the records that were kept
+	third := generateRecord(time.Hour)
+	reopened.Put(third)
+	reopened.Close()
+
+	again, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if got, _ := again.Get(third.Key); len(got) != 1 {
+		t.Errorf("Expected record appended after reopening to be found\n")
+	}
+}
+
+func TestFileStoreCompactsLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values")
+	store, _ := OpenFileStore(path)
+	r := generateRecord(time.Hour)
+
+	for i := 0; i < 3*minCompactEntries; i++ {
+		r.Replicated = time.Now()
+		store.Put(r)
+		store.MarkReplicated(r.Key, r.Publisher, time.Now())
+	}
+
+	if store.entries > minCompactEntries {
+		t.Errorf("Expected the log to be compacted, but it holds %d entries\n", store.entries)
+	}
+
+	info, _ := os.Stat(path)
+	if max := int64(minCompactEntries * len(appendPut(nil, r))); info.Size() > max {
+		t.Errorf("Expected the log to be at most %d bytes, but got %d\n", max, info.Size())
+	}
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if got, _ := reopened.Get(r.Key); len(got) != 1 {
+		t.Errorf("Expected record to survive compaction\n")
+	}
+}
+
+func TestDHTWithFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values")
+	store, _ := OpenFileStore(path)
+	key := GenerateRandomID()
+
+	DHTFrom(DHTConfig{Store: store}).Store(key, net.IPv4(10, 0, 0, 1), 4000)
+
+	reopened, _ := OpenFileStore(path)
+	if contacts, v := DHTFrom(DHTConfig{Store: reopened}).FindValue(key); contacts != nil || len(v) == 0 {
+		t.Errorf("Expected value to survive a restart\n")
+	}
+}
+
+func TestStoreKeepsOneRecordPerPublisher(t *testing.T) {
+	for name, store := range newStores(t) {
+		first := generateRecord(time.Hour)
+		second := generateRecord(time.Hour)
+		second.Key = first.Key
+
+		store.Put(first)
+		store.Put(second)
+
+		// publishing again replaces the publisher's record
+		first.Value = Value("updated")
+		store.Put(first)
+
+		got, _ := store.Get(first.Key)
+		if len(got) != 2 {
+			t.Fatalf("%s: Expected %d records, but got %d\n", name, 2, len(got))
+		}
+
+		for _, r := range got {
+			if r.Publisher.Equal(first.Publisher) && !bytes.Equal(r.Value, first.Value) {
+				t.Errorf("%s: Expected value %q, but got %q\n", name, first.Value, r.Value)
+			}
+		}
+
+		store.Delete(first.Key, first.Publisher)
+		if got, _ := store.Get(first.Key); len(got) != 1 || !got[0].Publisher.Equal(second.Publisher) {
+			t.Errorf("%s: Expected only the record of %s to be left\n", name, second.Publisher)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package gokad
+
+import (
+	"context"
+	"errors"
+)
+
+// Errors
+var ErrTransportClosed = errors.New("Transport Closed")
+var ErrNoTransport = errors.New("No Transport Configured")
+var ErrUnexpectedResponse = errors.New("Unexpected Response")
+
+// Handler serves a request received from the contact 'from' and returns the response.
+// If an error is returned no response is sent
+type Handler func(from Contact, req Message) (Message, error)
+
+// Transport carries messages between nodes.
+// The DHT sends all of its RPCs through a Transport and serves the requests it delivers
+type Transport interface {
+	// Call sends req to c and waits for the matching response
+	// until the response arrives, ctx is done or the transport is closed
+	Call(ctx context.Context, c Contact, req Message) (Message, error)
+	// Handle registers the handler incoming requests are delivered to.
+	// Requests arriving before a handler is registered are dropped
+	Handle(h Handler)
+	// Close stops the transport. Pending calls return with an error
+	Close() error
+}
+
+// checkResponse makes sure res answers req and, if the id of c is known, was sent by c
+func checkResponse(c Contact, req, res Message) bool {
+	if c.ID != nil && !res.SenderID.Equal(c.ID) {
+		return false
+	}
+
+	return res.Response && res.Type == req.Type && res.RPCID.Equal(req.RPCID)
+}
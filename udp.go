@@ -0,0 +1,189 @@
+package gokad
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// maxConcurrentRequests bounds the number of requests a UDPTransport serves at the same time.
+// Requests arriving while the bound is reached are dropped
+const maxConcurrentRequests = 64
+
+// UDPTransport is a Transport sending every Message as a single datagram bounded by MessageSize.
+// Requests are served concurrently, so a slow handler does not hold up the responses to our own calls
+type UDPTransport struct {
+	conn    *net.UDPConn
+	mu      sync.Mutex
+	handler Handler
+	pending map[string]pendingCall
+	serving chan struct{}
+	done    chan struct{}
+}
+
+// pendingCall is a call waiting for its response
+type pendingCall struct {
+	c   Contact
+	req Message
+	ch  chan Message
+}
+
+// ListenUDP listens on the udp address addr
+func ListenUDP(addr string) (*UDPTransport, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &UDPTransport{
+		conn:    conn,
+		pending: make(map[string]pendingCall),
+		serving: make(chan struct{}, maxConcurrentRequests),
+		done:    make(chan struct{}),
+	}
+
+	go t.readLoop()
+
+	return t, nil
+}
+
+// Addr returns the local address the transport is listening on
+func (t *UDPTransport) Addr() *net.UDPAddr {
+	return t.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Handle registers the handler incoming requests are delivered to
+func (t *UDPTransport) Handle(h Handler) {
+	t.mu.Lock()
+	t.handler = h
+	t.mu.Unlock()
+}
+
+// Close stops serving and closes the underlying connection
+func (t *UDPTransport) Close() error {
+	t.mu.Lock()
+	select {
+	case <-t.done:
+		t.mu.Unlock()
+		return nil
+	default:
+		close(t.done)
+	}
+	t.mu.Unlock()
+
+	return t.conn.Close()
+}
+
+// Call sends req to c and waits for the matching response
+func (t *UDPTransport) Call(ctx context.Context, c Contact, req Message) (Message, error) {
+	ch := make(chan Message, 1)
+	t.mu.Lock()
+	t.pending[string(req.RPCID)] = pendingCall{c: c, req: req, ch: ch}
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, string(req.RPCID))
+		t.mu.Unlock()
+	}()
+
+	if err := t.send(&net.UDPAddr{IP: c.IP, Port: c.Port}, req); err != nil {
+		return Message{}, err
+	}
+
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	case <-t.done:
+		return Message{}, ErrTransportClosed
+	}
+}
+
+func (t *UDPTransport) send(addr *net.UDPAddr, m Message) error {
+	b, err := m.Encode()
+	if err != nil {
+		return err
+	}
+
+	_, err = t.conn.WriteToUDP(b, addr)
+
+	return err
+}
+
+func (t *UDPTransport) readLoop() {
+	// one extra byte so oversized datagrams can be detected and dropped
+	buf := make([]byte, MessageSize+1)
+
+	for {
+		n, addr, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-t.done:
+				return
+			default:
+				continue
+			}
+		}
+
+		m, err := DecodeMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if m.Response {
+			t.deliver(m)
+			continue
+		}
+
+		t.mu.Lock()
+		h := t.handler
+		t.mu.Unlock()
+
+		if h == nil {
+			continue
+		}
+
+		select {
+		case t.serving <- struct{}{}:
+			go t.serve(h, addr, m)
+		default:
+		}
+	}
+}
+
+// serve answers the request m received from addr
+func (t *UDPTransport) serve(h Handler, addr *net.UDPAddr, m Message) {
+	defer func() { <-t.serving }()
+
+	res, err := h(Contact{ID: m.SenderID, IP: addr.IP, Port: addr.Port}, m)
+	if err != nil {
+		return
+	}
+
+	t.send(addr, res)
+}
+
+// deliver hands a response to the call waiting for it.
+// Responses that do not answer the call, e.g. sent by another node, are dropped
+// and the call keeps waiting
+func (t *UDPTransport) deliver(m Message) {
+	t.mu.Lock()
+	p, ok := t.pending[string(m.RPCID)]
+	t.mu.Unlock()
+
+	if !ok || !checkResponse(p.c, p.req, m) {
+		return
+	}
+
+	select {
+	case p.ch <- m:
+	default:
+	}
+}
@@ -0,0 +1,190 @@
+package gokad
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPStoreAndFindValue(t *testing.T) {
+	remote, c := newUDPNode(t)
+	defer remote.transport.Close()
+
+	local, _ := newUDPNode(t)
+	defer local.transport.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	key := GenerateRandomID()
+
+	_, providers, err := local.SendFindValue(ctx, c, key)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(providers) != 0 {
+		t.Fatalf("Expected value not to be found before it was stored\n")
+	}
+
+	if err := local.SendStore(ctx, c, key, net.IPv4(10, 0, 0, 1), 4000); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	_, providers, err = local.SendFindValue(ctx, c, key)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(providers) != 1 {
+		t.Fatalf("Expected %d value to be found, but got %d\n", 1, len(providers))
+	}
+
+	if !providers[0].Publisher.Equal(local.ID) {
+		t.Errorf("Expected publisher to be %s, but got %s\n", local.ID, providers[0].Publisher)
+	}
+
+	h, _ := ParseHostPort(providers[0].Value)
+	if !h.Host.Equal(net.IPv4(10, 0, 0, 1)) || h.Port != 4000 {
+		t.Errorf("Expected value to be 10.0.0.1:4000, but got %s:%d\n", h.Host, h.Port)
+	}
+}
+
+func TestUDPFindNode(t *testing.T) {
+	remote, c := newUDPNode(t)
+	defer remote.transport.Close()
+	expected := generateRandomContact()
+	remote.seen(expected)
+
+	local, _ := newUDPNode(t)
+	defer local.transport.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	contacts, err := local.SendFindNode(ctx, c, GenerateRandomID())
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(contacts) != 1 {
+		t.Fatalf("Expected %d contacts, but got %d\n", 1, len(contacts))
+	}
+
+	if !contacts[0].ID.Equal(expected.ID) || contacts[0].Port != expected.Port {
+		t.Errorf("Expected contact %s, but got %s\n", expected.ID, contacts[0].ID)
+	}
+}
+
+func TestUDPPing(t *testing.T) {
+	remote, c := newUDPNode(t)
+	defer remote.transport.Close()
+
+	local, _ := newUDPNode(t)
+	defer local.transport.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := local.SendPing(ctx, c); err != nil {
+		t.Errorf("Expected error to be nil, but got %s\n", err)
+	}
+}
+
+func TestUDPCallTimesOut(t *testing.T) {
+	local, _ := newUDPNode(t)
+	defer local.transport.Close()
+
+	// nobody is listening on this socket once it is closed
+	silent, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	addr := silent.LocalAddr().(*net.UDPAddr)
+	silent.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := local.SendFindNode(ctx, Contact{ID: GenerateRandomID(), IP: addr.IP, Port: addr.Port}, GenerateRandomID())
+	if err == nil {
+		t.Errorf("Expected an error, but got <nil>\n")
+	}
+}
+
+func TestUDPServesRequestsConcurrently(t *testing.T) {
+	a, _ := ListenUDP("127.0.0.1:0")
+	defer a.Close()
+	b, _ := ListenUDP("127.0.0.1:0")
+	defer b.Close()
+
+	idA, idB := GenerateRandomID(), GenerateRandomID()
+	a.Handle(func(from Contact, req Message) (Message, error) {
+		return NewResponse(req, idA, nil), nil
+	})
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	b.Handle(func(from Contact, req Message) (Message, error) {
+		close(entered)
+		<-release
+		return NewResponse(req, idB, nil), nil
+	})
+
+	go a.Call(context.Background(), Contact{ID: idB, IP: b.Addr().IP, Port: b.Addr().Port}, NewRequest(STORE, idA, nil))
+	<-entered
+
+	// b is still serving the STORE, but the response to its own ping gets through
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := b.Call(ctx, Contact{ID: idA, IP: a.Addr().IP, Port: a.Addr().Port}, NewRequest(PING, idB, nil)); err != nil {
+		t.Errorf("Expected error to be nil, but got %s\n", err)
+	}
+}
+
+func TestUDPDropsResponseFromOtherNode(t *testing.T) {
+	local, _ := ListenUDP("127.0.0.1:0")
+	defer local.Close()
+
+	remote, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer remote.Close()
+
+	id := GenerateRandomID()
+	go func() {
+		buf := make([]byte, MessageSize)
+		n, addr, err := remote.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		req, _ := DecodeMessage(buf[:n])
+		spoofed, _ := NewResponse(req, GenerateRandomID(), nil).Encode()
+		genuine, _ := NewResponse(req, id, nil).Encode()
+		remote.WriteToUDP(spoofed, addr)
+		remote.WriteToUDP(genuine, addr)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	addr := remote.LocalAddr().(*net.UDPAddr)
+	res, err := local.Call(ctx, Contact{ID: id, IP: addr.IP, Port: addr.Port}, NewRequest(PING, GenerateRandomID(), nil))
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if !res.SenderID.Equal(id) {
+		t.Errorf("Expected response from %s, but got %s\n", id, res.SenderID)
+	}
+}
+
+func newUDPNode(t *testing.T) (*DHT, Contact) {
+	transport, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	dht := DHTFrom(DHTConfig{Transport: transport})
+
+	return dht, Contact{ID: dht.ID, IP: transport.Addr().IP, Port: transport.Addr().Port}
+}
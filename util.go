@@ -1,25 +1,14 @@
 package gokad
 
-// bucketIndex is a utility function to get the bucket index
-// there are 160 buckets in a routing table.
-// if i have a distance of 01001111 in a 8 bit address space.
-// I often read/loop through distances starting at index 0, but in reality that is bucket 159
-// so in this case,
-// Bit:    0  1  0  0  1  1  1  1
-// Bucket: 7  6  5  4  3  2  1  0
-func bucketIndex(index int) int {
-	return (MaxRoutingTableSize - 1) - index
-}
-
 // compareDistance compares 2 distances to each other
 // return 1 if d1 is larger, -1 if d2 is larger and 0 if they are the same
 func compareDistance(d1, d2 Distance) int {
-	for i := 0; i < MaxCapacity; i++ {
-		if d1[i] > d2[i] {
+	for i := 0; i < len(d1); i++ {
+		if d1[i] > byteAt(d2, i) {
 			return 1
 		}
 
-		if d1[i] < d2[i] {
+		if d1[i] < byteAt(d2, i) {
 			return -1
 		}
 	}
@@ -39,3 +28,25 @@ func sort(x []Distance) {
 	}
 
 }
+
+// insertClosest inserts c into out, which is sorted by distance to target, closest first.
+// out holds at most n contacts, so c is dropped if n closer contacts are in out already
+func insertClosest(out []Contact, c Contact, target ID, n int) []Contact {
+	i := len(out)
+	for i > 0 && target.CompareDistanceTo(c.ID, out[i-1].ID) > 0 {
+		i--
+	}
+
+	if i >= n {
+		return out
+	}
+
+	if len(out) < n {
+		out = append(out, Contact{})
+	}
+
+	copy(out[i+1:], out[i:len(out)-1])
+	out[i] = c
+
+	return out
+}
@@ -2,33 +2,6 @@ package gokad
 
 import "testing"
 
-func TestBucketIndex(t *testing.T) {
-	cases := []struct {
-		IN  int
-		OUT int
-	}{
-		{
-			IN:  0,
-			OUT: 159,
-		},
-		{
-			IN:  159,
-			OUT: 0,
-		},
-		{
-			IN:  10,
-			OUT: 149,
-		},
-	}
-
-	for _, c := range cases {
-		index := bucketIndex(c.IN)
-		if index != c.OUT {
-			t.Errorf("Expected %d but got %d\n", c.OUT, index)
-		}
-	}
-}
-
 func TestSortDistance(t *testing.T) {
 	root, _ := From("C80F741BC1B397C54A54858E4E2A8840B2BC766B")
 	id1, _ := From("D80F741BC1B397C54A54858E4E2A8840B2BC766B")
@@ -0,0 +1,68 @@
+package gokad
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// Errors
+var ErrValueTooLarge = errors.New("Value Too Large")
+var ErrMalformedHostPort = errors.New("Malformed Host Port Record")
+
+// MaxValueSize is the largest value that fits into a single STORE request between nodes with ids of SIZE bytes
+const MaxValueSize = maxPayloadSize - 2*SIZE - 4
+
+// valueSizeLimit returns the largest value that fits into a single STORE request between nodes with ids of n bytes
+func valueSizeLimit(n int) int {
+	return payloadSize(n) - 2*n - 4
+}
+
+// Value is an opaque payload stored under a key
+type Value []byte
+
+// Provider is a value together with the id of the node that published it.
+// A key holds one value per publisher
+type Provider struct {
+	Publisher ID
+	Value     Value
+	// TTL is how much longer the node that returned the value keeps it.
+	// It is set for the values returned by FindValue and Get
+	TTL time.Duration
+}
+
+// HostPort is a peer location record, the value type the DHT was originally built for.
+// Use Value to store it and ParseHostPort to read it back
+type HostPort struct {
+	Host net.IP
+	Port int
+}
+
+// Value encodes the record as 2 bytes port <- 4 bytes ip for IPv4 or 16 bytes ip for IPv6
+func (h HostPort) Value() Value {
+	ip := []byte(h.Host.To4())
+	if ip == nil {
+		ip = []byte(h.Host.To16())
+	}
+
+	out := make(Value, 2, 2+len(ip))
+	binary.BigEndian.PutUint16(out, uint16(h.Port))
+
+	return append(out, ip...)
+}
+
+// ParseHostPort is the inverse of HostPort.Value
+func ParseHostPort(v Value) (HostPort, error) {
+	if len(v) != 2+net.IPv4len && len(v) != 2+net.IPv6len {
+		return HostPort{}, ErrMalformedHostPort
+	}
+
+	host := make(net.IP, len(v)-2)
+	copy(host, v[2:])
+
+	return HostPort{
+		Host: host,
+		Port: int(binary.BigEndian.Uint16(v[:2])),
+	}, nil
+}
@@ -0,0 +1,115 @@
+package gokad
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHostPortRoundTrip(t *testing.T) {
+	cases := []struct {
+		record HostPort
+		size   int
+	}{
+		{HostPort{Host: net.IPv4(10, 0, 0, 1), Port: 4000}, 2 + net.IPv4len},
+		{HostPort{Host: net.ParseIP("2001:db8::1"), Port: 65535}, 2 + net.IPv6len},
+	}
+
+	for _, test := range cases {
+		v := test.record.Value()
+		if len(v) != test.size {
+			t.Errorf("Expected %s to encode to %d bytes, but got %d\n", test.record.Host, test.size, len(v))
+		}
+
+		out, err := ParseHostPort(v)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, but got %s\n", err)
+		}
+
+		if !out.Host.Equal(test.record.Host) || out.Port != test.record.Port {
+			t.Errorf("Expected %s:%d, but got %s:%d\n", test.record.Host, test.record.Port, out.Host, out.Port)
+		}
+	}
+
+	if _, err := ParseHostPort(Value("not a record")); !errors.Is(err, ErrMalformedHostPort) {
+		t.Errorf("Expected error %s, but got %v\n", ErrMalformedHostPort, err)
+	}
+}
+
+func TestPutGetArbitraryValue(t *testing.T) {
+	network := NewMemoryNetwork()
+	nodes, _ := newMemoryCluster(network, 10, 6)
+
+	key := GenerateRandomID()
+	manifest := bytes.Repeat([]byte("manifest "), MaxValueSize/9)
+
+	if _, err := nodes[0].Put(context.Background(), key, manifest); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	providers, err := nodes[len(nodes)-1].Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if len(providers) != 1 || !bytes.Equal(providers[0].Value, manifest) {
+		t.Errorf("Expected the %d bytes manifest, but got %v\n", len(manifest), providers)
+	}
+}
+
+func TestValueSizeIsLimited(t *testing.T) {
+	network := NewMemoryNetwork()
+	local, _ := newMemoryNode(network)
+	remote := DHTFrom(DHTConfig{Transport: network.NewTransport(), MaxValueSize: 8})
+	addr := remote.transport.(*MemoryTransport).Addr()
+	local.RoutingTable().Add(Contact{ID: remote.ID, IP: addr.IP, Port: addr.Port})
+
+	key := GenerateRandomID()
+	if _, err := local.Put(context.Background(), key, make(Value, MaxValueSize+1)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Expected error %s, but got %v\n", ErrValueTooLarge, err)
+	}
+
+	if err := remote.StoreValue(key, make(Value, 9)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Expected error %s, but got %v\n", ErrValueTooLarge, err)
+	}
+
+	// the remote node refuses values above its own limit
+	if _, err := local.Put(context.Background(), key, make(Value, 9)); !errors.Is(err, ErrNoReplicas) {
+		t.Errorf("Expected error %s, but got %v\n", ErrNoReplicas, err)
+	}
+}
+
+func TestOversizedValuesFromOtherNodesAreDropped(t *testing.T) {
+	network := NewMemoryNetwork()
+	remote, c := newMemoryNode(network)
+	local := DHTFrom(DHTConfig{Transport: network.NewTransport(), MaxValueSize: 10})
+	local.RoutingTable().Add(c)
+
+	key := GenerateRandomID()
+	remote.StoreValue(key, make(Value, 700))
+
+	if _, err := local.Get(context.Background(), key); !errors.Is(err, ErrValueNotFound) {
+		t.Errorf("Expected error %s, but got %v\n", ErrValueNotFound, err)
+	}
+}
+
+func TestFindValueLimitsValues(t *testing.T) {
+	dht := DHTFrom(DHTConfig{MaxValues: 2})
+	key := GenerateRandomID()
+
+	for i := 0; i < 3; i++ {
+		dht.storeValue(key, Provider{Publisher: GenerateRandomID(), Value: Value("provider")}, time.Duration(i+1)*time.Hour)
+	}
+
+	contacts, providers := dht.FindValue(key)
+	if contacts != nil {
+		t.Fatalf("Expected values to be found\n")
+	}
+
+	if len(providers) != 2 {
+		t.Errorf("Expected %d values, but got %d\n", 2, len(providers))
+	}
+}